@@ -6,11 +6,16 @@
 package main
 
 import (
+	"crypto/tls"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
 	"time"
 
@@ -18,10 +23,45 @@ import (
 )
 
 type Config struct {
-	Addr      []string // TCP addresses to listen on. e.g. ":1234", "1.2.3.4:1234" or "[::1]:1234"
-	MaxForks  int      // Number of allowable concurrent forks
-	LogLevel  libwebsocketd.LogLevel
-	RedirPort int
+	Addr          []string // Addresses to listen on. e.g. ":1234", "1.2.3.4:1234", "[::1]:1234" or "unix:/run/ws.sock"
+	ListenFDs     int      // Number of sockets passed by systemd socket activation
+	ListenFDNames []string // Names of sockets passed by systemd socket activation
+	UnixMode      os.FileMode
+	UnixUID       int // Owner of unix sockets, -1 means unchanged
+	UnixGID       int // Group of unix sockets, -1 means unchanged
+
+	InheritedFDs   int      // Number of sockets handed over by previous websocketd process
+	InheritedKinds []string // What each of handed over sockets is used for
+	ReadyFD        int      // Pipe to tell previous websocketd process that handover is complete
+
+	ConfigFile  string // Configuration file given with --config
+	PrintConfig string // Effective configuration to print instead of serving (--print-config)
+
+	DrainTimeout     time.Duration // Time given to sessions to finish on SIGTERM/SIGINT
+	DrainCloseCode   int           // WebSocket close code sent to clients on shutdown
+	DrainCloseReason string        // WebSocket close reason sent to clients on shutdown
+	MaxForks         int           // Number of allowable concurrent forks
+	LogLevel         libwebsocketd.LogLevel
+	LogFormat        string        // One of logFormats keys
+	LogDest          string        // "stdout", "syslog" or "journald"
+	LogFacility      int           // Syslog facility code
+	LogFile          string        // Write log to this file instead of stdout
+	LogMaxSize       int64         // Rotate LogFile once it grows past this many bytes
+	LogMaxAge        time.Duration // Rotate LogFile once it was written for that long
+	LogMaxFiles      int           // Number of rotated log files to keep
+	LogCompress      bool          // Gzip rotated log files
+	RedirPort        int
+	MetricsAddr      string   // Separate address to serve metrics on (--metricsaddr)
+	MetricsAddrPath  string   // URL path of metrics on MetricsAddr
+	AdminAddr        string   // Address to serve admin API on (--adminaddr)
+	AdminToken       string   // Bearer token admin API requests should carry
+	CertFile         string   // TLS certificate PEM file used with --ssl
+	KeyFile          string   // TLS private key PEM file used with --ssl
+	TLSMinVersion    uint16   // Minimal TLS protocol version accepted by listeners
+	TLSCiphers       []uint16 // TLS cipher suites allowed for TLS 1.0-1.2 (nil means Go defaults)
+	ClientAuth       tls.ClientAuthType
+	ClientCAFile     string // CA bundle to verify client certificates against
+	CRLFile          string // Certificate revocation list for client certificates
 	*libwebsocketd.Config
 }
 
@@ -48,104 +88,436 @@ var defaultPassEnv = map[string]string{
 	"windows": "PATH,SystemRoot,COMSPEC,PATHEXT,WINDIR",
 }
 
+// adminTokenEnv is environment variable admin API token could be passed in
+const adminTokenEnv = "WEBSOCKETD_ADMIN_TOKEN"
+
+// usageError is reported along with short help on how to run websocketd.
+type usageError string
+
+func (e usageError) Error() string { return string(e) }
+
+var (
+	errPrintVersion = errors.New("version requested")
+	errPrintLicense = errors.New("license requested")
+)
+
+// parseCommandLine builds configuration from process arguments and environment,
+// printing problems (or requested help) and exiting if it can't.
 func parseCommandLine() *Config {
+	config, err := parseConfig(os.Args[1:], os.Environ())
+	switch err {
+	case nil:
+		if config.PrintConfig != "" {
+			fmt.Print(config.PrintConfig)
+			os.Exit(0)
+		}
+		for _, key := range inheritEnvs {
+			os.Unsetenv(key)
+		}
+		return config
+	case flag.ErrHelp:
+		PrintHelp()
+		os.Exit(0)
+	case errPrintVersion:
+		fmt.Printf("%s %s\n", HelpProcessName(), Version())
+		os.Exit(0)
+	case errPrintLicense:
+		fmt.Printf("%s %s\n", HelpProcessName(), Version())
+		fmt.Printf("%s\n", libwebsocketd.License)
+		os.Exit(0)
+	}
+
+	if _, ok := err.(flagError); ok {
+		// flag package has already explained what is wrong
+		ShortHelp()
+		os.Exit(2)
+	}
+	fmt.Fprintf(os.Stderr, "%s\n", err)
+	if _, ok := err.(usageError); ok {
+		ShortHelp()
+	}
+	os.Exit(1)
+	return nil
+}
+
+// flagError is returned when arguments could not be parsed by flag package.
+type flagError struct{ error }
+
+// parseConfig builds configuration from command line arguments (without program
+// name) and environment given as KEY=value list. It is called once on startup
+// and again on every configuration reload.
+func parseConfig(arguments []string, environ []string) (*Config, error) {
 	var mainConfig Config
 	var config libwebsocketd.Config
 
-	flag.Usage = func() {}
-	flag.CommandLine = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	flags := flag.NewFlagSet(HelpProcessName(), flag.ContinueOnError)
+	flags.Usage = func() {}
 
 	// If adding new command line options, also update the help text in help.go.
 	// The flag library's auto-generate help message isn't pretty enough.
 
 	addrlist := Arglist(make([]string, 0, 1)) // pre-reserve for 1 address
-	flag.Var(&addrlist, "address", "Interfaces to bind to (e.g. 127.0.0.1 or [::1]).")
+	flags.Var(&addrlist, "address", "Interfaces to bind to (e.g. 127.0.0.1, [::1] or unix:/path/to/socket).")
+	unixModeFlag := flags.String("unixmode", "", "Permissions of unix sockets in octal (e.g. 0660)")
+	unixOwnerFlag := flags.String("unixowner", "", "Owner of unix sockets as user[:group]")
 
 	// server config options
-	portFlag := flag.Int("port", 0, "HTTP port to listen on")
-	versionFlag := flag.Bool("version", false, "Print version and exit")
-	licenseFlag := flag.Bool("license", false, "Print license and exit")
-	logLevelFlag := flag.String("loglevel", "access", "Log level, one of: debug, trace, access, info, error, fatal")
-	maxForksFlag := flag.Int("maxforks", 0, "Max forks, zero means unlimited")
-	closeMsFlag := flag.Uint("closems", 0, "Time to start sending signals (0 never)")
-	redirPortFlag := flag.Int("redirport", 0, "HTTP port to redirect to canonical --port address")
+	portFlag := flags.Int("port", 0, "HTTP port to listen on")
+	versionFlag := flags.Bool("version", false, "Print version and exit")
+	licenseFlag := flags.Bool("license", false, "Print license and exit")
+	configFlag := flags.String("config", "", "Configuration file (.yaml, .toml or .json), flags override its values")
+	printConfigFlag := flags.Bool("print-config", false, "Print effective configuration and exit")
+	logLevelFlag := flags.String("loglevel", "access", "Log level, one of: debug, trace, access, info, error, fatal")
+	stderrLevelFlag := flags.String("stderrlevel", "error", "Log level of process stderr lines, or none")
+	stderrCategoryFlag := flags.String("stderrcategory", "stderr", "Log category of process stderr lines")
+	stderrLineLimitFlag := flags.Int("stderrlinelimit", 4096, "Longer stderr lines are cut to this many bytes")
+	stderrForwardFlag := flags.String("stderrforward", "", "Send stderr lines to client too: binary or prefix")
+	stderrPrefixFlag := flags.String("stderrprefix", "stderr: ", "Prefix of stderr lines sent with --stderrforward=prefix")
+	logFormatFlag := flags.String("logformat", "text", "Log format, one of: text, json, logfmt")
+	logDestFlag := flags.String("log", "stdout", "Log destination: stdout, syslog[:facility] or journald")
+	logFileFlag := flags.String("logfile", "", "Write log to this file instead of stdout")
+	logMaxSizeFlag := flags.Int64("logmaxsize", 0, "Rotate --logfile once it grows past this many megabytes")
+	logMaxAgeFlag := flags.Duration("logmaxage", 0, "Rotate --logfile once it was written for that long")
+	logMaxFilesFlag := flags.Int("logmaxfiles", 0, "Number of rotated log files to keep, 0 keeps all")
+	logCompressFlag := flags.Bool("logcompress", false, "Gzip rotated log files")
+	maxForksFlag := flags.Int("maxforks", 0, "Max forks, zero means unlimited")
+	closeMsFlag := flags.Uint("closems", 0, "Time to start sending signals (0 never)")
+	drainTimeoutFlag := flags.Duration("drain-timeout", 5*time.Second, "Time to wait for sessions to finish on shutdown")
+	drainCloseCodeFlag := flags.Int("drain-closecode", 1001, "WebSocket close code sent to clients on shutdown")
+	drainCloseReasonFlag := flags.String("drain-closereason", "", "WebSocket close reason sent to clients on shutdown")
+	redirPortFlag := flags.Int("redirport", 0, "HTTP port to redirect to canonical --port address")
+	metricsFlag := flags.String("metrics", "", "URL path to serve Prometheus metrics on")
+	metricsAddrFlag := flags.String("metricsaddr", "", "Separate address (host:port) to serve metrics on")
+	healthzFlag := flags.String("healthz", "", "URL path of liveness check, disabled unless set")
+	readyzFlag := flags.String("readyz", "", "URL path of readiness check, disabled unless set")
+	readyzForksFlag := flags.Int("readyz-forks", 90, "Not ready once this percentage of --maxforks is in use")
+	readyzProbeFlag := flags.String("readyz-probe", "", "Command that has to succeed for readiness check to pass")
+	readyzTimeoutFlag := flags.Duration("readyz-timeout", 2*time.Second, "Time given to --readyz-probe command")
+	adminAddrFlag := flags.String("adminaddr", "", "Address (host:port) to serve admin API on")
+	adminTokenFlag := flags.String("admintoken", "", "Token admin API requests are authorized with")
+	adminTokenFileFlag := flags.String("admintoken-file", "", "Read --admintoken from this file")
+	sslFlag := flags.Bool("ssl", false, "Use TLS on listening socket (see also --sslcert and --sslkey)")
+	sslCert := flags.String("sslcert", "", "Should point to certificate PEM file when --ssl is used")
+	sslKey := flags.String("sslkey", "", "Should point to certificate private key file when --ssl is used")
+	sslMinVersion := flags.String("sslminversion", "1.2", "Minimal TLS version to accept, one of: 1.0, 1.1, 1.2, 1.3")
+	sslCiphers := flags.String("sslciphers", "", "Comma separated list of allowed TLS cipher suites")
+	sslClientCA := flags.String("sslclientca", "", "CA bundle PEM file to verify client certificates with")
+	sslClientAuth := flags.String("sslclientauth", "", "Client certificate policy, one of: none, optional, require")
+	sslCRL := flags.String("sslcrl", "", "Certificate revocation list (PEM or DER) for client certificates")
 
 	// lib config options
-	binaryFlag := flag.Bool("binary", false, "Set websocketd to experimental binary mode (default is line by line)")
-	reverseLookupFlag := flag.Bool("reverselookup", false, "Perform reverse DNS lookups on remote clients")
-	passEnvFlag := flag.String("passenv", defaultPassEnv[runtime.GOOS], "List of envvars to pass to subprocesses (others will be cleaned out)")
-	sameOriginFlag := flag.Bool("sameorigin", false, "Restrict upgrades if origin and host headers differ")
-	allowOriginsFlag := flag.String("origin", "", "Restrict upgrades if origin does not match the list")
+	binaryFlag := flags.Bool("binary", false, "Set websocketd to experimental binary mode (default is line by line)")
+	reverseLookupFlag := flags.Bool("reverselookup", false, "Perform reverse DNS lookups on remote clients")
+	passEnvFlag := flags.String("passenv", defaultPassEnv[runtime.GOOS], "List of envvars to pass to subprocesses (others will be cleaned out)")
+	sameOriginFlag := flags.Bool("sameorigin", false, "Restrict upgrades if origin and host headers differ")
+	allowOriginsFlag := flags.String("origin", "", "Restrict upgrades if origin does not match the list")
+	scriptDirFlag := flags.String("dir", "", "Base directory for WebSocket scripts")
+	staticDirFlag := flags.String("staticdir", "", "Serve static content from this directory over HTTP")
+	devConsoleFlag := flags.Bool("devconsole", false, "Enable interactive development console in browser")
+	cgiDirFlag := flags.String("cgidir", "", "Serve CGI scripts from this directory over HTTP")
+	staticListingFlag := flags.Bool("staticlisting", false, "Show listings of --staticdir directories without index.html")
+	queryEnvFlag := flags.Bool("queryenv", false, "Pass decoded query parameters as QUERY_<NAME> variables")
+	cookieEnvFlag := flags.Bool("cookieenv", false, "Pass cookies as COOKIE_<NAME> variables")
+	parsedEnvLimitFlag := flags.Int("parsedenvlimit", 16384, "Total size of QUERY_* and COOKIE_* variables in bytes")
+	envFileFlag := flags.String("envfile", "", "Read KEY=value variables to pass to the process from file")
+	recordFlag := flags.String("record", "", "Write transcript of every session to this directory")
+	recordLimitFlag := flags.Int64("recordlimit", 10, "Stop recording messages once transcript grows past this many megabytes, 0 means no limit")
 
 	headers := Arglist(make([]string, 0))
-	flag.Var(&headers, "header", "Custom headers for any response.")
+	headersWs := Arglist(make([]string, 0))
+	headersHTTP := Arglist(make([]string, 0))
+	flags.Var(&headers, "header", "Custom headers for any response.")
+	flags.Var(&headersWs, "header-ws", "Custom headers for successful WebSocket upgrade responses.")
+	flags.Var(&headersHTTP, "header-http", "Custom headers for all but WebSocket upgrade HTTP responses.")
+	routeList := Arglist(make([]string, 0))
+	flags.Var(&routeList, "route", "Serve URL path with its own command (/path=command args).")
+	setEnv := Arglist(make([]string, 0))
+	flags.Var(&setEnv, "setenv", "Pass KEY=value variable to the process.")
 
-	err := flag.CommandLine.Parse(os.Args[1:])
-	if err != nil {
-		if err == flag.ErrHelp {
-			PrintHelp()
-			os.Exit(0)
-		} else {
-			ShortHelp()
-			os.Exit(2)
+	err := flags.Parse(arguments)
+	if err == flag.ErrHelp {
+		return nil, err
+	} else if err != nil {
+		return nil, flagError{err}
+	}
+	if *versionFlag {
+		return nil, errPrintVersion
+	}
+	if *licenseFlag {
+		return nil, errPrintLicense
+	}
+	if len(arguments) == 0 {
+		return nil, usageError("Command line arguments are missing.")
+	}
+
+	var fileRoutes *configValue
+	explicit := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	args := flags.Args()
+	if *configFlag != "" {
+		table, err := loadConfigFile(*configFlag)
+		if err != nil {
+			return nil, err
 		}
+		if err := applyConfigFile(*configFlag, table, flags, explicit); err != nil {
+			return nil, err
+		}
+		if command, ok := table.values["command"]; ok && len(args) == 0 {
+			if args, err = command.strings(); err != nil {
+				return nil, fmt.Errorf("%s:%s", *configFlag, err)
+			}
+			if len(args) == 1 {
+				args = strings.Fields(args[0])
+			}
+		}
+		if !explicit["route"] {
+			fileRoutes = table.values["routes"]
+		}
+		mainConfig.ConfigFile = *configFlag
 	}
 
 	port := *portFlag
 	if port == 0 {
-		port = 80
+		if *sslFlag {
+			port = 443
+		} else {
+			port = 80
+		}
+	}
+
+	// systemd socket activation, see sd_listen_fds(3)
+	if pid, err := strconv.Atoi(getenv(environ, "LISTEN_PID")); err == nil && pid == os.Getpid() {
+		mainConfig.ListenFDs, _ = strconv.Atoi(getenv(environ, "LISTEN_FDS"))
+		if names := getenv(environ, "LISTEN_FDNAMES"); names != "" {
+			mainConfig.ListenFDNames = strings.Split(names, ":")
+		}
+	}
+	// sockets handed over by previous websocketd process (see handoff.go)
+	if n, err := strconv.Atoi(getenv(environ, inheritFdsEnv)); err == nil && n > 0 {
+		mainConfig.InheritedFDs = n
+		mainConfig.InheritedKinds = strings.Split(getenv(environ, inheritKindsEnv), ":")
+		mainConfig.ReadyFD, _ = strconv.Atoi(getenv(environ, handoffReadyEnv))
 	}
 
 	if socknum := len(addrlist); socknum != 0 {
 		mainConfig.Addr = make([]string, socknum)
 		for i, addrSingle := range addrlist {
-			mainConfig.Addr[i] = fmt.Sprintf("%s:%d", addrSingle, port)
+			if strings.HasPrefix(addrSingle, unixAddrPrefix) {
+				mainConfig.Addr[i] = addrSingle
+			} else {
+				mainConfig.Addr[i] = fmt.Sprintf("%s:%d", addrSingle, port)
+			}
 		}
-	} else {
+	} else if mainConfig.ListenFDs == 0 || *portFlag != 0 {
 		mainConfig.Addr = []string{fmt.Sprintf(":%d", port)}
 	}
+
+	if *unixModeFlag != "" {
+		mode, err := strconv.ParseUint(*unixModeFlag, 8, 32)
+		if err != nil || mode > 0777 {
+			return nil, fmt.Errorf("Incorrect --unixmode '%s', it should be octal number like 0660.", *unixModeFlag)
+		}
+		mainConfig.UnixMode = os.FileMode(mode)
+	}
+	mainConfig.UnixUID, mainConfig.UnixGID, err = parseOwner(*unixOwnerFlag)
+	if err != nil {
+		return nil, fmt.Errorf("Incorrect --unixowner '%s': %s", *unixOwnerFlag, err)
+	}
 	mainConfig.MaxForks = *maxForksFlag
 	mainConfig.RedirPort = *redirPortFlag
+	mainConfig.MetricsAddr = *metricsAddrFlag
+	mainConfig.AdminAddr = *adminAddrFlag
+	// token is better kept out of command line where every user could see it
+	mainConfig.AdminToken = *adminTokenFlag
+	if *adminTokenFileFlag != "" {
+		if mainConfig.AdminToken != "" {
+			return nil, errors.New("--admintoken and --admintoken-file cannot be used together.")
+		}
+		data, err := ioutil.ReadFile(*adminTokenFileFlag)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to read --admintoken-file: %s", err)
+		}
+		mainConfig.AdminToken = strings.TrimSpace(string(data))
+	}
+	if mainConfig.AdminToken == "" {
+		mainConfig.AdminToken = getenv(environ, adminTokenEnv)
+	}
+	if mainConfig.AdminAddr != "" && mainConfig.AdminToken == "" {
+		return nil, fmt.Errorf("Please provide --admintoken-file, %s or --admintoken to use --adminaddr.", adminTokenEnv)
+	}
+	mainConfig.DrainTimeout = *drainTimeoutFlag
+	mainConfig.DrainCloseCode = *drainCloseCodeFlag
+	mainConfig.DrainCloseReason = *drainCloseReasonFlag
+	if *drainCloseCodeFlag < 1000 || *drainCloseCodeFlag > 4999 || len(*drainCloseReasonFlag) > 123 {
+		return nil, errors.New("Incorrect --drain-closecode or --drain-closereason (code 1000-4999, reason up to 123 bytes).")
+	}
 	mainConfig.LogLevel = libwebsocketd.LevelFromString(*logLevelFlag)
-	if mainConfig.LogLevel == libwebsocketd.LogUnknown {
-		fmt.Printf("Incorrect loglevel flag '%s'. Use --help to see allowed values.\n", *logLevelFlag)
-		ShortHelp()
-		os.Exit(1)
+	// "none" is meant for --stderrlevel only, server log can't be switched off
+	if mainConfig.LogLevel == libwebsocketd.LogUnknown || mainConfig.LogLevel == libwebsocketd.LogNone {
+		return nil, usageError(fmt.Sprintf("Incorrect loglevel flag '%s'. Use --help to see allowed values.", *logLevelFlag))
+	}
+	if _, ok := logFormats[*logFormatFlag]; !ok {
+		return nil, usageError(fmt.Sprintf("Incorrect logformat flag '%s'. Use --help to see allowed values.", *logFormatFlag))
+	}
+	mainConfig.LogFormat = *logFormatFlag
+	if *logMaxSizeFlag < 0 || *logMaxAgeFlag < 0 || *logMaxFilesFlag < 0 {
+		return nil, errors.New("Incorrect --logmaxsize, --logmaxage or --logmaxfiles, they should not be negative.")
+	}
+	mainConfig.LogFile = *logFileFlag
+	mainConfig.LogDest = *logDestFlag
+	mainConfig.LogFacility = syslogFacilities["daemon"]
+	if strings.HasPrefix(mainConfig.LogDest, "syslog:") {
+		facility, ok := syslogFacilities[strings.TrimPrefix(mainConfig.LogDest, "syslog:")]
+		if !ok {
+			return nil, usageError(fmt.Sprintf("Incorrect syslog facility in --log=%s. Use --help to see allowed values.", *logDestFlag))
+		}
+		mainConfig.LogDest, mainConfig.LogFacility = "syslog", facility
+	}
+	switch mainConfig.LogDest {
+	case "stdout":
+	case "syslog", "journald":
+		if mainConfig.LogFile != "" {
+			return nil, fmt.Errorf("--logfile cannot be used with --log=%s.", *logDestFlag)
+		}
+	default:
+		return nil, usageError(fmt.Sprintf("Incorrect log flag '%s'. Use --help to see allowed values.", *logDestFlag))
 	}
+	mainConfig.LogMaxSize = *logMaxSizeFlag * 1024 * 1024
+	mainConfig.LogMaxAge = *logMaxAgeFlag
+	mainConfig.LogMaxFiles = *logMaxFilesFlag
+	mainConfig.LogCompress = *logCompressFlag
 
+	if *sslFlag {
+		if *sslCert == "" || *sslKey == "" {
+			return nil, errors.New("Please specify both --sslcert and --sslkey when requesting --ssl.")
+		}
+		mainConfig.TLSMinVersion, err = parseTLSVersion(*sslMinVersion)
+		if err != nil {
+			return nil, fmt.Errorf("Incorrect --sslminversion: %s", err)
+		}
+		mainConfig.TLSCiphers, err = parseTLSCiphers(*sslCiphers)
+		if err != nil {
+			return nil, fmt.Errorf("Incorrect --sslciphers: %s", err)
+		}
+		clientAuth := *sslClientAuth
+		if clientAuth == "" {
+			clientAuth = "none"
+			if *sslClientCA != "" {
+				clientAuth = "require"
+			}
+		}
+		mainConfig.ClientAuth, err = parseClientAuth(clientAuth)
+		if err != nil {
+			return nil, fmt.Errorf("Incorrect --sslclientauth: %s", err)
+		}
+		if mainConfig.ClientAuth != tls.NoClientCert && *sslClientCA == "" {
+			return nil, errors.New("Please specify --sslclientca when requesting client certificates.")
+		}
+		if *sslCRL != "" && *sslClientCA == "" {
+			return nil, errors.New("--sslcrl could only be used together with --sslclientca.")
+		}
+	} else {
+		if *sslCert != "" || *sslKey != "" || *sslCiphers != "" || *sslClientCA != "" || *sslClientAuth != "" || *sslCRL != "" {
+			return nil, errors.New("You should not be using --ssl* flags when there is no --ssl option.")
+		}
+	}
+	mainConfig.CertFile = *sslCert
+	mainConfig.KeyFile = *sslKey
+	mainConfig.ClientCAFile = *sslClientCA
+	mainConfig.CRLFile = *sslCRL
+
+	for _, hdrs := range [][]string{headers, headersWs, headersHTTP} {
+		for _, h := range hdrs {
+			if strings.IndexByte(h, ':') <= 0 {
+				return nil, fmt.Errorf("Incorrect header '%s', it should look like \"Name: value\".", h)
+			}
+		}
+	}
 	config.Headers = []string(headers)
+	config.HeadersWs = []string(headersWs)
+	config.HeadersHTTP = []string(headersHTTP)
 
 	config.CloseMs = *closeMsFlag
 	config.Binary = *binaryFlag
 	config.ReverseLookup = *reverseLookupFlag
-	config.StartupTime = time.Now()
-	config.ServerSoftware = fmt.Sprintf("websocketd/%s", Version())
-	config.HandshakeTimeout = time.Millisecond * 1500 // only default for now
-
-	if len(os.Args) == 1 {
-		fmt.Printf("Command line arguments are missing.\n")
-		ShortHelp()
-		os.Exit(1)
+	config.Ssl = *sslFlag
+	config.ScriptDir = *scriptDirFlag
+	config.StaticDir = *staticDirFlag
+	config.StaticListing = *staticListingFlag
+	config.CgiDir = *cgiDirFlag
+	config.DevConsole = *devConsoleFlag
+	config.StderrLevel = libwebsocketd.LevelFromString(*stderrLevelFlag)
+	if config.StderrLevel == libwebsocketd.LogUnknown {
+		return nil, usageError(fmt.Sprintf("Incorrect stderrlevel flag '%s'. Use --help to see allowed values.", *stderrLevelFlag))
 	}
-
-	if *versionFlag {
-		fmt.Printf("%s %s\n", HelpProcessName(), Version())
-		os.Exit(0)
+	config.StderrCategory = *stderrCategoryFlag
+	config.StderrLineLimit = *stderrLineLimitFlag
+	if *stderrLineLimitFlag < 16 {
+		return nil, errors.New("Incorrect --stderrlinelimit, it should be at least 16.")
+	}
+	switch *stderrForwardFlag {
+	case "", libwebsocketd.StderrForwardPrefix:
+	case libwebsocketd.StderrForwardBinary:
+		if *binaryFlag {
+			return nil, errors.New("--stderrforward=binary cannot be used with --binary, use prefix instead.")
+		}
+	default:
+		return nil, usageError(fmt.Sprintf("Incorrect stderrforward flag '%s'. Use --help to see allowed values.", *stderrForwardFlag))
+	}
+	config.StderrForward = *stderrForwardFlag
+	config.StderrPrefix = *stderrPrefixFlag
+	config.QueryEnv = *queryEnvFlag
+	config.CookieEnv = *cookieEnvFlag
+	config.ParsedEnvLimit = *parsedEnvLimitFlag
+	if *metricsFlag != "" && !strings.HasPrefix(*metricsFlag, "/") {
+		return nil, errors.New("Incorrect --metrics, it should be URL path starting with /.")
+	}
+	for _, path := range []string{*healthzFlag, *readyzFlag} {
+		if path != "" && !strings.HasPrefix(path, "/") {
+			return nil, fmt.Errorf("Incorrect health check path '%s', it should start with /.", path)
+		}
+	}
+	if *readyzForksFlag < 0 || *readyzForksFlag > 100 {
+		return nil, errors.New("Incorrect --readyz-forks, it should be percentage 0-100.")
+	}
+	config.HealthPath = *healthzFlag
+	config.ReadyPath = *readyzFlag
+	config.ReadyForksPercent = *readyzForksFlag
+	if probe := strings.Fields(*readyzProbeFlag); len(probe) > 0 {
+		if *readyzFlag == "" {
+			return nil, errors.New("Please provide --readyz to use --readyz-probe.")
+		}
+		path, err := lookPath(probe[0], getenv(environ, "PATH"))
+		if err != nil {
+			return nil, fmt.Errorf("Unable to locate --readyz-probe command '%s' in OS path.", probe[0])
+		}
+		config.ReadyProbe = append([]string{path}, probe[1:]...)
 	}
+	config.ReadyProbeTimeout = *readyzTimeoutFlag
 
-	if *licenseFlag {
-		fmt.Printf("%s %s\n", HelpProcessName(), Version())
-		fmt.Printf("%s\n", libwebsocketd.License)
-		os.Exit(0)
+	if mainConfig.MetricsAddr != "" {
+		// metrics are kept off the main listeners then
+		mainConfig.MetricsAddrPath = *metricsFlag
+		if mainConfig.MetricsAddrPath == "" {
+			mainConfig.MetricsAddrPath = "/metrics"
+		}
+	} else {
+		config.MetricsPath = *metricsFlag
+	}
+	if *parsedEnvLimitFlag < 0 {
+		return nil, errors.New("Incorrect --parsedenvlimit, it should not be negative.")
 	}
+	config.StartupTime = time.Now()
+	config.ServerSoftware = fmt.Sprintf("websocketd/%s", Version())
+	config.HandshakeTimeout = time.Millisecond * 1500 // only default for now
 
 	// Building config.ParentEnv to avoid calling Environ all the time in the scripts
 	// (caller is responsible for wiping environment if desired)
 	config.ParentEnv = make([]string, 0)
 	newlineCleaner := strings.NewReplacer("\n", " ", "\r", " ")
 	for _, key := range strings.Split(*passEnvFlag, ",") {
-		if key != "HTTPS" {
-			if v := os.Getenv(key); v != "" {
+		if key != "HTTPS" && !strings.HasPrefix(key, "SSL_") {
+			if v := getenv(environ, key); v != "" {
 				// inevitably adding flavor of libwebsocketd appendEnv func.
 				// it's slightly nicer than in net/http/cgi implementation
 				if clean := strings.TrimSpace(newlineCleaner.Replace(v)); clean != "" {
@@ -160,23 +532,126 @@ func parseCommandLine() *Config {
 	}
 	config.SameOrigin = *sameOriginFlag
 
-	args := flag.Args()
-	if len(args) < 1 {
-		fmt.Fprintf(os.Stderr, "Please specify COMMAND.\n")
-		ShortHelp()
-		os.Exit(1)
+	// --envfile goes first so --setenv could override or refer to its variables.
+	env := newEnvBuilder(environ)
+	if *envFileFlag != "" {
+		if err := env.loadFile(*envFileFlag); err != nil {
+			return nil, fmt.Errorf("Unable to read --envfile: %s", err)
+		}
+	}
+	for _, kv := range setEnv {
+		if err := env.assign(kv); err != nil {
+			return nil, fmt.Errorf("Incorrect --setenv: %s.", err)
+		}
 	}
+	config.Env = env.list()
 
-	if path, err := exec.LookPath(args[0]); err == nil {
-		config.CommandName = path // This can be command in PATH that we are able to execute
-		config.CommandArgs = flag.Args()[1:]
-	} else {
-		fmt.Fprintf(os.Stderr, "Unable to locate specified COMMAND '%s' in OS path.\n", args[0])
-		ShortHelp()
-		os.Exit(1)
+	for _, r := range routeList {
+		route, err := parseRoute(r, &config, getenv(environ, "PATH"))
+		if err != nil {
+			return nil, err
+		}
+		config.Routes = append(config.Routes, route)
+	}
+	if fileRoutes != nil {
+		routes, err := routesFromConfigFile(*configFlag, fileRoutes, &config, environ)
+		if err != nil {
+			return nil, err
+		}
+		config.Routes = append(config.Routes, routes...)
+	}
+
+	if len(args) < 1 && config.ScriptDir == "" && config.StaticDir == "" && config.CgiDir == "" && len(config.Routes) == 0 {
+		return nil, usageError("Please specify COMMAND or provide --dir, --route, --staticdir or --cgidir argument.")
+	}
+
+	if len(args) > 0 {
+		if config.ScriptDir != "" {
+			return nil, usageError("Ambiguous. Provided COMMAND and --dir argument. Please only specify just one.")
+		}
+		if path, err := lookPath(args[0], getenv(environ, "PATH")); err == nil {
+			config.CommandName = path // This can be command in PATH that we are able to execute
+			config.CommandArgs = args[1:]
+			config.UsingScriptDir = false
+		} else {
+			return nil, usageError(fmt.Sprintf("Unable to locate specified COMMAND '%s' in OS path.", args[0]))
+		}
+	}
+
+	if config.ScriptDir != "" {
+		scriptDir, err := filepath.Abs(config.ScriptDir)
+		if err != nil {
+			return nil, usageError(fmt.Sprintf("Could not resolve absolute path to dir '%s'.", config.ScriptDir))
+		}
+		inf, err := os.Stat(scriptDir)
+		if err != nil {
+			return nil, usageError(fmt.Sprintf("Could not find your script dir '%s'.", config.ScriptDir))
+		}
+		if !inf.IsDir() {
+			return nil, usageError(fmt.Sprintf("Did you mean to specify COMMAND instead of --dir '%s'?", config.ScriptDir))
+		}
+		config.ScriptDir = scriptDir
+		config.UsingScriptDir = true
+	}
+
+	if config.StaticDir != "" {
+		if inf, err := os.Stat(config.StaticDir); err != nil || !inf.IsDir() {
+			return nil, usageError(fmt.Sprintf("Could not find your static dir '%s'.", config.StaticDir))
+		}
+	}
+	if config.CgiDir != "" {
+		cgiDir, err := filepath.Abs(config.CgiDir)
+		if inf, serr := os.Stat(cgiDir); err != nil || serr != nil || !inf.IsDir() {
+			return nil, usageError(fmt.Sprintf("Could not find your CGI dir '%s'.", config.CgiDir))
+		}
+		config.CgiDir = cgiDir
+	}
+	if *recordFlag != "" {
+		if inf, err := os.Stat(*recordFlag); err != nil || !inf.IsDir() {
+			return nil, usageError(fmt.Sprintf("Could not find your record dir '%s'.", *recordFlag))
+		}
+	}
+	if *recordLimitFlag < 0 {
+		return nil, errors.New("Incorrect --recordlimit, it should not be negative.")
+	}
+	config.RecordDir = *recordFlag
+	config.RecordLimit = *recordLimitFlag * 1024 * 1024
+	if *staticListingFlag && config.StaticDir == "" {
+		return nil, errors.New("--staticlisting could only be used together with --staticdir.")
+	}
+
+	if *printConfigFlag {
+		mainConfig.PrintConfig = dumpConfig(flags, args, config.Routes)
 	}
 
 	mainConfig.Config = &config
 
-	return &mainConfig
+	return &mainConfig, nil
+}
+
+// getenv looks key up in environ list of KEY=value strings.
+func getenv(environ []string, key string) string {
+	for i := len(environ) - 1; i >= 0; i-- {
+		if strings.HasPrefix(environ[i], key+"=") {
+			return environ[i][len(key)+1:]
+		}
+	}
+	return ""
+}
+
+// lookPath is exec.LookPath searching given PATH value rather than current
+// environment, which is wiped after startup.
+func lookPath(file string, path string) (string, error) {
+	if strings.ContainsAny(file, `/\`) {
+		return exec.LookPath(file)
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		if found, err := exec.LookPath(filepath.Join(dir, file)); err == nil {
+			return found, nil
+		}
+	}
+	return "", &exec.Error{Name: file, Err: exec.ErrNotFound}
 }
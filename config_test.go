@@ -0,0 +1,88 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "websocketd-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("from-file\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		args    []string
+		environ []string
+		token   string
+	}{
+		{[]string{"--admintoken=from-flag"}, []string{adminTokenEnv + "=from-env"}, "from-flag"},
+		{[]string{"--admintoken-file=" + tokenFile}, []string{adminTokenEnv + "=from-env"}, "from-file"},
+		{nil, []string{adminTokenEnv + "=from-env"}, "from-env"},
+		{nil, nil, ""},
+		{[]string{"--admintoken=a", "--admintoken-file=" + tokenFile}, nil, ""},
+	}
+	for _, tt := range tests {
+		args := append([]string{"--adminaddr=127.0.0.1:0", "--print-config"}, tt.args...)
+		config, err := parseConfig(append(args, "cat"), append(tt.environ, "PATH=/bin:/usr/bin"))
+		if tt.token == "" {
+			if err == nil {
+				t.Errorf("%q %q should be rejected", tt.args, tt.environ)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q %q: %s", tt.args, tt.environ, err)
+			continue
+		}
+		if config.AdminToken != tt.token {
+			t.Errorf("%q %q: got token %q, expected %q", tt.args, tt.environ, config.AdminToken, tt.token)
+		}
+		if strings.Contains(config.PrintConfig, tt.token) {
+			t.Errorf("token should not be printed by --print-config:\n%s", config.PrintConfig)
+		}
+	}
+}
+
+func TestReadyProbeLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "websocketd-probe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	probe := filepath.Join(dir, "check-ready")
+	if err := ioutil.WriteFile(probe, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := parseConfig([]string{"--readyz=/readyz", "--readyz-probe=check-ready -q", "cat"}, []string{"PATH=/bin:/usr/bin:" + dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.ReadyProbe) != 2 || config.ReadyProbe[0] != probe || config.ReadyProbe[1] != "-q" {
+		t.Errorf("probe should be resolved in configured PATH, got %q", config.ReadyProbe)
+	}
+	if config.HealthPath != "" {
+		t.Errorf("health check should be disabled by default, got %q", config.HealthPath)
+	}
+
+	if _, err := parseConfig([]string{"--readyz=/readyz", "--readyz-probe=check-ready", "cat"}, []string{"PATH=/bin:/usr/bin"}); err == nil {
+		t.Error("probe missing from configured PATH was accepted")
+	}
+	if _, err := parseConfig([]string{"--readyz-probe=" + probe, "cat"}, []string{"PATH=/bin:/usr/bin"}); err == nil {
+		t.Error("probe without --readyz was accepted")
+	}
+}
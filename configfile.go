@@ -0,0 +1,810 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/joewalnes/websocketd/libwebsocketd"
+)
+
+// Configuration file mirrors command line: every key is the name of a flag
+// (e.g. "port", "header-ws", "sslcert"), lists could be used for flags that
+// are allowed to be repeated or take comma separated values, and "command" is
+// COMMAND with its arguments (list, or string split at spaces) and "routes" is
+// a list of tables describing routes (see routes.go).
+//
+// Only the subset of YAML and TOML needed for that is understood, anything else
+// is reported as unsupported feature with its line:
+//
+//   YAML: block mappings and lists, flow lists of scalars, plain and quoted
+//         scalars; no flow mappings, block scalars, anchors, aliases or tags.
+//   TOML: key = value with strings, numbers, booleans, arrays and inline tables,
+//         [[routes]]; no other [table] headers, dotted keys or multi-line strings.
+//   JSON: everything, through encoding/json.
+
+// configKind tells which of configValue fields are in use
+type configKind int
+
+const (
+	scalarValue configKind = iota
+	listValue
+	tableValue
+)
+
+type configValue struct {
+	kind   configKind
+	line   int // where value (or its key) starts in the file
+	scalar string
+	list   []*configValue
+	table  *configTable
+}
+
+// configTable is a mapping that remembers order of keys.
+type configTable struct {
+	keys   []string
+	values map[string]*configValue
+}
+
+func newConfigTable() *configTable {
+	return &configTable{values: make(map[string]*configValue)}
+}
+
+func (t *configTable) set(key string, v *configValue) error {
+	if prev, ok := t.values[key]; ok {
+		return &configError{v.line, fmt.Sprintf("duplicate key '%s' (first seen on line %d)", key, prev.line)}
+	}
+	t.keys = append(t.keys, key)
+	t.values[key] = v
+	return nil
+}
+
+// strings returns scalar as single element list, items of lists are required to be scalars.
+func (v *configValue) strings() ([]string, error) {
+	switch v.kind {
+	case scalarValue:
+		return []string{v.scalar}, nil
+	case listValue:
+		s := make([]string, 0, len(v.list))
+		for _, item := range v.list {
+			if item.kind != scalarValue {
+				return nil, &configError{item.line, "list items should be plain values"}
+			}
+			s = append(s, item.scalar)
+		}
+		return s, nil
+	}
+	return nil, &configError{v.line, "value should not be a table"}
+}
+
+// configError is a problem in configuration file found at given line.
+type configError struct {
+	line int
+	msg  string
+}
+
+func (e *configError) Error() string {
+	return fmt.Sprintf("%d: %s", e.line, e.msg)
+}
+
+// unsupported is configError for syntax that is valid in format, but not
+// understood by its parser here.
+func unsupported(line int, format, feature string) error {
+	return &configError{line, fmt.Sprintf("unsupported %s feature: %s", format, feature)}
+}
+
+// notInConfigFile lists flags that make no sense as configuration file keys.
+var notInConfigFile = map[string]bool{
+	"config":       true,
+	"print-config": true,
+	"help":         true,
+	"version":      true,
+	"license":      true,
+}
+
+// loadConfigFile reads configuration file choosing its format by extension.
+func loadConfigFile(path string) (*configTable, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var table *configTable
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		table, err = parseYAML(data)
+	case ".toml":
+		table, err = parseTOML(data)
+	case ".json":
+		table, err = parseJSON(data)
+	default:
+		return nil, fmt.Errorf("unknown format of configuration file %s, use .yaml, .toml or .json", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s", path, err)
+	}
+	return table, nil
+}
+
+// applyConfigFile sets flags from configuration file unless they were given on
+// command line. All unknown keys and bad values are reported at once.
+func applyConfigFile(path string, table *configTable, flags *flag.FlagSet, explicit map[string]bool) error {
+	problems := make([]string, 0)
+	report := func(line int, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s:%d: %s", path, line, fmt.Sprintf(format, args...)))
+	}
+
+	for _, key := range table.keys {
+		v := table.values[key]
+		if key == "command" || key == "routes" {
+			continue // used by caller instead of COMMAND argument and --route
+		}
+		f := flags.Lookup(key)
+		if f == nil || notInConfigFile[key] {
+			report(v.line, "unknown key '%s'", key)
+			continue
+		}
+		if explicit[key] {
+			continue
+		}
+		values, err := v.strings()
+		if err != nil {
+			report(err.(*configError).line, "%s: %s", key, err.(*configError).msg)
+			continue
+		}
+		if _, repeated := f.Value.(*Arglist); !repeated {
+			values = []string{strings.Join(values, ",")}
+		}
+		for _, value := range values {
+			if err := flags.Set(key, value); err != nil {
+				report(v.line, "invalid value %q for %s: %s", value, key, err)
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// dumpConfig prints effective flag values and command as YAML configuration
+// file. Admin token is left out, it's a secret.
+func dumpConfig(flags *flag.FlagSet, command []string, routes []*libwebsocketd.Route) string {
+	var b bytes.Buffer
+	flags.VisitAll(func(f *flag.Flag) {
+		if notInConfigFile[f.Name] || f.Name == "route" {
+			return // --route values are printed among routes
+		}
+		if f.Name == "admintoken" && f.Value.String() != "" {
+			b.WriteString("# admintoken is not printed, use admintoken-file instead\n")
+			return
+		}
+		if al, ok := f.Value.(*Arglist); ok {
+			b.WriteString(yamlList("", f.Name, []string(*al)))
+		} else {
+			fmt.Fprintf(&b, "%s: %s\n", f.Name, yamlScalar(f.Value.String()))
+		}
+	})
+	b.WriteString(yamlList("", "command", command))
+	b.WriteString(dumpRoutes(routes))
+	return b.String()
+}
+
+func yamlList(indent string, key string, values []string) string {
+	if len(values) == 0 {
+		return fmt.Sprintf("%s%s: []\n", indent, key)
+	}
+	s := fmt.Sprintf("%s%s:\n", indent, key)
+	for _, v := range values {
+		s += fmt.Sprintf("%s  - %s\n", indent, yamlScalar(v))
+	}
+	return s
+}
+
+// yamlScalar quotes s if it would not be read back as the same plain string.
+func yamlScalar(s string) string {
+	if s == "" || s != strings.TrimSpace(s) || strings.ContainsAny(s, ":#[]{},\"'\\\n\t") ||
+		strings.IndexAny(s[:1], "-?!&*|>%@`~") == 0 || s == "null" {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+//
+// YAML subset: block mappings and sequences, flow sequences, plain and quoted scalars
+//
+
+type yamlLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+func parseYAML(data []byte) (*configTable, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, "\r")
+		text := stripYAMLComment(raw)
+		trimmed := strings.TrimLeft(text, " ")
+		if trimmed == "" || trimmed == "---" {
+			continue
+		}
+		if trimmed == "..." {
+			break
+		}
+		if trimmed[0] == '\t' {
+			return nil, &configError{i + 1, "tabs are not allowed in indentation"}
+		}
+		p.lines = append(p.lines, yamlLine{i + 1, len(text) - len(trimmed), trimmed})
+	}
+	if len(p.lines) == 0 {
+		return newConfigTable(), nil
+	}
+
+	v, err := p.parseNode(p.lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		return nil, &configError{p.lines[p.pos].num, "unexpected indentation"}
+	}
+	if v.kind != tableValue {
+		return nil, &configError{v.line, "top level should be a mapping of keys to values"}
+	}
+	return v.table, nil
+}
+
+func (p *yamlParser) parseNode(indent int) (*configValue, error) {
+	if isYAMLListItem(p.lines[p.pos].text) {
+		return p.parseList(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+func (p *yamlParser) parseList(indent int) (*configValue, error) {
+	v := &configValue{kind: listValue, line: p.lines[p.pos].num, list: []*configValue{}}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent != indent || !isYAMLListItem(l.text) {
+			break
+		}
+		rest := strings.TrimLeft(l.text[1:], " ")
+
+		var item *configValue
+		var err error
+		switch {
+		case rest == "":
+			p.pos++
+			if p.pos < len(p.lines) && p.lines[p.pos].indent > indent {
+				item, err = p.parseNode(p.lines[p.pos].indent)
+			} else {
+				item = &configValue{kind: scalarValue, line: l.num}
+			}
+		case isYAMLListItem(rest) || isYAMLMappingEntry(rest):
+			// nested node starts on the same line as "- ", let's pretend it's on its own
+			nested := indent + len(l.text) - len(rest)
+			p.lines[p.pos] = yamlLine{l.num, nested, rest}
+			item, err = p.parseNode(nested)
+		default:
+			p.pos++
+			item, err = parseYAMLFlow(rest, l.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		v.list = append(v.list, item)
+	}
+	return v, nil
+}
+
+func (p *yamlParser) parseMapping(indent int) (*configValue, error) {
+	v := &configValue{kind: tableValue, line: p.lines[p.pos].num, table: newConfigTable()}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, &configError{l.num, "unexpected indentation"}
+		}
+		if isYAMLListItem(l.text) {
+			return nil, &configError{l.num, "unexpected list item"}
+		}
+		key, rest, ok := splitYAMLEntry(l.text)
+		if !ok {
+			return nil, &configError{l.num, "expected 'key: value'"}
+		}
+		p.pos++
+
+		var item *configValue
+		var err error
+		if rest != "" {
+			item, err = parseYAMLFlow(rest, l.num)
+		} else if p.pos < len(p.lines) && (p.lines[p.pos].indent > indent ||
+			p.lines[p.pos].indent == indent && isYAMLListItem(p.lines[p.pos].text)) {
+			item, err = p.parseNode(p.lines[p.pos].indent)
+		} else {
+			item = &configValue{kind: scalarValue}
+		}
+		if err != nil {
+			return nil, err
+		}
+		item.line = l.num
+		if err := v.table.set(key, item); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func isYAMLListItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+func isYAMLMappingEntry(text string) bool {
+	_, _, ok := splitYAMLEntry(text)
+	return ok
+}
+
+// splitYAMLEntry splits "key: value" at the first colon that is followed by space
+// and is not inside of quotes.
+func splitYAMLEntry(text string) (key, rest string, ok bool) {
+	quote := byte(0)
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i+1 == len(text) || text[i+1] == ' '):
+			key = strings.TrimSpace(text[:i])
+			if len(key) >= 2 && (key[0] == '"' || key[0] == '\'') {
+				k, err := parseYAMLScalar(key, 0)
+				if err != nil {
+					return "", "", false
+				}
+				key = k.scalar
+			}
+			return key, strings.TrimSpace(text[i+1:]), key != ""
+		}
+	}
+	return "", "", false
+}
+
+// stripYAMLComment cuts "# comment" which starts line or follows a space outside of quotes.
+func stripYAMLComment(raw string) string {
+	quote := byte(0)
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			if i == 0 || strings.IndexByte(" [,", raw[i-1]) >= 0 {
+				quote = c
+			}
+		case c == '#':
+			if i == 0 || raw[i-1] == ' ' || raw[i-1] == '\t' {
+				return strings.TrimRight(raw[:i], " \t")
+			}
+		}
+	}
+	return strings.TrimRight(raw, " \t")
+}
+
+// parseYAMLFlow parses value written on the same line as its key: scalar or [a, b].
+func parseYAMLFlow(s string, line int) (*configValue, error) {
+	switch s[0] {
+	case '[':
+		if s[len(s)-1] != ']' {
+			return nil, &configError{line, "list is not closed with ]"}
+		}
+		v := &configValue{kind: listValue, line: line, list: []*configValue{}}
+		inner := strings.TrimSpace(s[1 : len(s)-1])
+		for inner != "" {
+			end := len(inner)
+			quote := byte(0)
+			for i := 0; i < len(inner); i++ {
+				c := inner[i]
+				if quote != 0 {
+					if c == '\\' && quote == '"' {
+						i++
+					} else if c == quote {
+						quote = 0
+					}
+				} else if c == '"' || c == '\'' {
+					quote = c
+				} else if c == '[' || c == '{' {
+					return nil, unsupported(line, "YAML", "nested flow lists and mappings")
+				} else if c == ',' {
+					end = i
+					break
+				}
+			}
+			item, err := parseYAMLScalar(strings.TrimSpace(inner[:end]), line)
+			if err != nil {
+				return nil, err
+			}
+			v.list = append(v.list, item)
+			if end == len(inner) {
+				break
+			}
+			inner = strings.TrimSpace(inner[end+1:])
+		}
+		return v, nil
+	case '{':
+		return nil, unsupported(line, "YAML", "flow mappings, use indented block")
+	case '|', '>':
+		return nil, unsupported(line, "YAML", "block scalars, use quoted string")
+	case '&', '*', '!':
+		return nil, unsupported(line, "YAML", "anchors, aliases and tags")
+	}
+	return parseYAMLScalar(s, line)
+}
+
+func parseYAMLScalar(s string, line int) (*configValue, error) {
+	v := &configValue{kind: scalarValue, line: line}
+	switch {
+	case s == "" || s == "~" || s == "null":
+	case s[0] == '"':
+		unquoted, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, &configError{line, fmt.Sprintf("bad double quoted string %s", s)}
+		}
+		v.scalar = unquoted
+	case s[0] == '\'':
+		if len(s) < 2 || s[len(s)-1] != '\'' || strings.Contains(strings.Replace(s[1:len(s)-1], "''", "", -1), "'") {
+			return nil, &configError{line, fmt.Sprintf("bad single quoted string %s", s)}
+		}
+		v.scalar = strings.Replace(s[1:len(s)-1], "''", "'", -1)
+	default:
+		v.scalar = s
+	}
+	return v, nil
+}
+
+//
+// TOML subset: key = value pairs, [table] and [[array of tables]] headers, strings,
+// numbers, booleans, arrays and inline tables
+//
+
+type tomlParser struct {
+	data string
+	pos  int
+	line int
+}
+
+func parseTOML(data []byte) (*configTable, error) {
+	p := &tomlParser{data: string(data), line: 1}
+	root := newConfigTable()
+	current := root
+	for {
+		p.skip(true)
+		if p.pos >= len(p.data) {
+			return root, nil
+		}
+		line := p.line
+
+		if p.data[p.pos] == '[' {
+			many := strings.HasPrefix(p.data[p.pos:], "[[")
+			closing := "]"
+			p.pos++
+			if many {
+				closing = "]]"
+				p.pos++
+			}
+			p.skip(false)
+			key, err := p.key()
+			if err != nil {
+				return nil, err
+			}
+			p.skip(false)
+			if !strings.HasPrefix(p.data[p.pos:], closing) {
+				return nil, p.errorf("expected %s", closing)
+			}
+			p.pos += len(closing)
+			if err := p.lineEnd(); err != nil {
+				return nil, err
+			}
+
+			// the only table configuration has is list of routes
+			if !many || key != "routes" {
+				header := "[" + key + "]"
+				if many {
+					header = "[" + header + "]"
+				}
+				return nil, unsupported(line, "TOML", "table "+header+", only [[routes]] could be used")
+			}
+			current = newConfigTable()
+			item := &configValue{kind: tableValue, line: line, table: current}
+			list, ok := root.values[key]
+			if !ok {
+				list = &configValue{kind: listValue, line: line}
+				root.set(key, list)
+			} else if list.kind != listValue {
+				return nil, &configError{line, fmt.Sprintf("'%s' is not an array of tables", key)}
+			}
+			list.list = append(list.list, item)
+			continue
+		}
+
+		if err := p.keyValue(current); err != nil {
+			return nil, err
+		}
+		if err := p.lineEnd(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *tomlParser) errorf(format string, args ...interface{}) error {
+	return &configError{p.line, fmt.Sprintf(format, args...)}
+}
+
+// skip moves over spaces and comments, and over line ends too if newlines is set.
+func (p *tomlParser) skip(newlines bool) {
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		case c == '#':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *tomlParser) lineEnd() error {
+	p.skip(false)
+	if p.pos < len(p.data) && p.data[p.pos] != '\n' {
+		return p.errorf("unexpected '%c' after value", p.data[p.pos])
+	}
+	return nil
+}
+
+func (p *tomlParser) keyValue(table *configTable) error {
+	line := p.line
+	key, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skip(false)
+	if p.pos >= len(p.data) || p.data[p.pos] != '=' {
+		return p.errorf("expected '=' after '%s'", key)
+	}
+	p.pos++
+	p.skip(false)
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+	v.line = line
+	return table.set(key, v)
+}
+
+func (p *tomlParser) key() (string, error) {
+	if p.pos < len(p.data) && (p.data[p.pos] == '"' || p.data[p.pos] == '\'') {
+		return p.str()
+	}
+	start := p.pos
+	for p.pos < len(p.data) && isTOMLBareChar(p.data[p.pos]) {
+		p.pos++
+	}
+	if p.pos < len(p.data) && p.data[p.pos] == '.' {
+		return "", unsupported(p.line, "TOML", "dotted keys")
+	}
+	if start == p.pos {
+		return "", p.errorf("expected key")
+	}
+	return p.data[start:p.pos], nil
+}
+
+func isTOMLBareChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) str() (string, error) {
+	quote := p.data[p.pos]
+	if strings.HasPrefix(p.data[p.pos:], strings.Repeat(string(quote), 3)) {
+		return "", unsupported(p.line, "TOML", "multi-line strings")
+	}
+	start := p.pos
+	for p.pos++; p.pos < len(p.data) && p.data[p.pos] != '\n'; p.pos++ {
+		c := p.data[p.pos]
+		if c == '\\' && quote == '"' {
+			p.pos++
+		} else if c == quote {
+			p.pos++
+			if quote == '\'' {
+				return p.data[start+1 : p.pos-1], nil
+			}
+			s, err := strconv.Unquote(p.data[start:p.pos])
+			if err != nil {
+				return "", p.errorf("bad string %s", p.data[start:p.pos])
+			}
+			return s, nil
+		}
+	}
+	return "", p.errorf("string is not terminated")
+}
+
+func (p *tomlParser) value() (*configValue, error) {
+	v := &configValue{kind: scalarValue, line: p.line}
+	if p.pos >= len(p.data) {
+		return nil, p.errorf("expected value")
+	}
+	switch p.data[p.pos] {
+	case '"', '\'':
+		s, err := p.str()
+		v.scalar = s
+		return v, err
+	case '[':
+		p.pos++
+		v.kind, v.list = listValue, []*configValue{}
+		for {
+			p.skip(true)
+			if p.pos < len(p.data) && p.data[p.pos] == ']' {
+				p.pos++
+				return v, nil
+			}
+			item, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			v.list = append(v.list, item)
+			p.skip(true)
+			if p.pos < len(p.data) && p.data[p.pos] == ',' {
+				p.pos++
+			} else if p.pos >= len(p.data) || p.data[p.pos] != ']' {
+				return nil, p.errorf("expected ',' or ']' in array")
+			}
+		}
+	case '{':
+		p.pos++
+		v.kind, v.table = tableValue, newConfigTable()
+		for {
+			p.skip(false)
+			if p.pos < len(p.data) && p.data[p.pos] == '}' {
+				p.pos++
+				return v, nil
+			}
+			if err := p.keyValue(v.table); err != nil {
+				return nil, err
+			}
+			p.skip(false)
+			if p.pos < len(p.data) && p.data[p.pos] == ',' {
+				p.pos++
+			} else if p.pos >= len(p.data) || p.data[p.pos] != '}' {
+				return nil, p.errorf("expected ',' or '}' in inline table")
+			}
+		}
+	}
+
+	// numbers, booleans and dates are passed on as they are written
+	start := p.pos
+	for p.pos < len(p.data) && (isTOMLBareChar(p.data[p.pos]) || strings.IndexByte(".:+", p.data[p.pos]) >= 0) {
+		p.pos++
+	}
+	if start == p.pos {
+		return nil, p.errorf("unexpected '%c'", p.data[p.pos])
+	}
+	v.scalar = p.data[start:p.pos]
+	if _, err := strconv.ParseFloat(strings.Replace(v.scalar, "_", "", -1), 64); err == nil {
+		v.scalar = strings.Replace(v.scalar, "_", "", -1)
+	}
+	return v, nil
+}
+
+//
+// JSON goes through encoding/json tokenizer to keep track of line numbers
+//
+
+func parseJSON(data []byte) (*configTable, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	lineAt := func(offset int64) int {
+		return 1 + bytes.Count(data[:offset], []byte("\n"))
+	}
+
+	v, err := readJSONValue(dec, lineAt)
+	if err != nil {
+		if se, ok := err.(*json.SyntaxError); ok {
+			return nil, &configError{lineAt(se.Offset), se.Error()}
+		}
+		if err == errUnexpectedJSONEnd {
+			return nil, &configError{lineAt(int64(len(data))), err.Error()}
+		}
+		return nil, err
+	}
+	if v.kind != tableValue {
+		return nil, &configError{v.line, "top level should be an object"}
+	}
+	return v.table, nil
+}
+
+var errUnexpectedJSONEnd = errors.New("unexpected end of JSON input")
+
+func readJSONValue(dec *json.Decoder, lineAt func(int64) int) (*configValue, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		if err == io.EOF {
+			return nil, errUnexpectedJSONEnd
+		}
+		return nil, err
+	}
+	v := &configValue{kind: scalarValue, line: lineAt(dec.InputOffset())}
+	switch t := tok.(type) {
+	case json.Delim:
+		if t == '[' {
+			v.kind, v.list = listValue, []*configValue{}
+			for dec.More() {
+				item, err := readJSONValue(dec, lineAt)
+				if err != nil {
+					return nil, err
+				}
+				v.list = append(v.list, item)
+			}
+		} else {
+			v.kind, v.table = tableValue, newConfigTable()
+			for dec.More() {
+				keyTok, err := dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				line := lineAt(dec.InputOffset())
+				item, err := readJSONValue(dec, lineAt)
+				if err != nil {
+					return nil, err
+				}
+				item.line = line
+				if err := v.table.set(keyTok.(string), item); err != nil {
+					return nil, err
+				}
+			}
+		}
+		if _, err := dec.Token(); err != nil { // closing bracket
+			return nil, err
+		}
+	case string:
+		v.scalar = t
+	case json.Number:
+		v.scalar = t.String()
+	case bool:
+		v.scalar = strconv.FormatBool(t)
+	case nil:
+	}
+	return v, nil
+}
@@ -0,0 +1,251 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// dumpValue renders parsed value in a compact form to compare results of
+// different formats: scalars are quoted, lists are in [] and tables in {}.
+// Line numbers differ between formats and are checked by error tests.
+func dumpValue(v *configValue) string {
+	switch v.kind {
+	case listValue:
+		items := make([]string, len(v.list))
+		for i, item := range v.list {
+			items[i] = dumpValue(item)
+		}
+		return "[" + strings.Join(items, " ") + "]"
+	case tableValue:
+		return dumpTable(v.table)
+	}
+	return fmt.Sprintf("%q", v.scalar)
+}
+
+func dumpTable(t *configTable) string {
+	items := make([]string, len(t.keys))
+	for i, key := range t.keys {
+		items[i] = key + ":" + dumpValue(t.values[key])
+	}
+	return "{" + strings.Join(items, " ") + "}"
+}
+
+const expectedConfigTable = `{port:"8080" header:["X-A: b \"q\"" "it's"] origin:["a.com" "b.com"] ` +
+	`command:["cat" "-u"] routes:[{path:"/chat/{room}" command:"cat -n" env:{ROOM:"x # y"}} {path:"/b" binary:"true"}]}`
+
+var configFormatTests = []struct {
+	ext, data string
+}{
+	{".yaml", `# comment
+port: 8080
+header: ["X-A: b \"q\"", 'it''s']
+origin: [a.com, b.com]   # trailing comment
+command: [cat, "-u"]
+routes:
+  - path: "/chat/{room}"
+    command: cat -n
+    env:
+      ROOM: "x # y"
+  - path: /b
+    binary: true
+`},
+	{".yml", `---
+port: 8080
+header:
+  - "X-A: b \"q\""
+  - 'it''s'
+origin:
+- a.com
+- b.com
+command: [cat, "-u"]
+routes:
+- path: "/chat/{room}"
+  command: "cat -n"
+  env:
+    ROOM: 'x # y'
+- path: /b
+  binary: true
+...
+`},
+	{".toml", `# comment
+port = 8080
+header = ["X-A: b \"q\"", "it's"]
+origin = [
+  "a.com", 'b.com', # trailing comma
+]
+command = ["cat", "-u"]
+[[routes]]
+path = "/chat/{room}"
+command = 'cat -n'
+env = { ROOM = "x # y" }
+[[routes]]
+path = "/b"
+binary = true
+`},
+	{".json", `{
+  "port": 8080,
+  "header": ["X-A: b \"q\"", "it's"],
+  "origin": ["a.com", "b.com"],
+  "command": ["cat", "-u"],
+  "routes": [{
+    "path": "/chat/{room}",
+    "command": "cat -n",
+    "env": {
+      "ROOM": "x # y"}},
+    {"path": "/b",
+    "binary": true}]
+}`},
+}
+
+func writeConfigFile(t *testing.T, dir, ext, data string) string {
+	path := filepath.Join(dir, "websocketd"+ext)
+	if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigFileFormats(t *testing.T) {
+	dir, err := ioutil.TempDir("", "websocketd-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range configFormatTests {
+		table, err := loadConfigFile(writeConfigFile(t, dir, tt.ext, tt.data))
+		if err != nil {
+			t.Errorf("%s: %s", tt.ext, err)
+			continue
+		}
+		if got := dumpTable(table); got != expectedConfigTable {
+			t.Errorf("%s parsed as\n%s\nexpected\n%s", tt.ext, got, expectedConfigTable)
+		}
+	}
+}
+
+var configErrorTests = []struct {
+	ext, data, err string
+}{
+	{".yaml", "port: 80\n\tsslcert: x\n", ":2: tabs are not allowed"},
+	{".yaml", "port: 80\n\n# c\nport: 81\n", ":4: duplicate key 'port' (first seen on line 1)"},
+	{".yaml", "port: 80\n  ssl: true\n", ":2: unexpected indentation"},
+	{".yaml", "a: 1\nheader: [a, b\n", ":2: list is not closed"},
+	{".yaml", "a: 1\nheader: \"open\n", ":2: bad double quoted string"},
+	{".yaml", "a: 1\nb: 'it's'\n", ":2: bad single quoted string"},
+	{".yaml", "a: 1\nb: {c: d}\n", ":2: unsupported YAML feature: flow mappings"},
+	{".yaml", "a: 1\nb: |\n  text\n", ":2: unsupported YAML feature: block scalars"},
+	{".yaml", "a: 1\nb: &x 1\n", ":2: unsupported YAML feature: anchors"},
+	{".yaml", "a: 1\nb: [[c]]\n", ":2: unsupported YAML feature: nested flow lists"},
+	{".yaml", "- a\n- b\n", ":1: top level should be a mapping"},
+	{".yaml", "a: 1\njust text\n", ":2: expected 'key: value'"},
+	{".toml", "port = 80\nssl true\n", ":2: expected '=' after 'ssl'"},
+	{".toml", "a = 1\n\nb = \"open\n", ":3: string is not terminated"},
+	{".toml", "a.b = 1\n", ":1: unsupported TOML feature: dotted keys"},
+	{".toml", "a = 1 2\n", ":1: unexpected '2' after value"},
+	{".toml", "a = [1,\n 2\n 3]\n", ":3: expected ',' or ']' in array"},
+	{".toml", "a = 1\n[routes]\na = 1\n", ":2: unsupported TOML feature: table [routes], only [[routes]] could be used"},
+	{".toml", "a = 1\n[ssl]\ncert = \"x\"\n", ":2: unsupported TOML feature: table [ssl]"},
+	{".toml", "a = 1\n[[header]]\n", ":2: unsupported TOML feature: table [[header]]"},
+	{".toml", "a = 1\n[[routes.env]]\n", ":2: unsupported TOML feature: dotted keys"},
+	{".toml", "routes = \"x\"\n[[routes]]\n", ":2: 'routes' is not an array of tables"},
+	{".toml", "a = \"\"\"x\"\"\"\n", ":1: unsupported TOML feature: multi-line strings"},
+	{".toml", "a = 1\nb = '''x'''\n", ":2: unsupported TOML feature: multi-line strings"},
+	{".json", "{\n  \"a\": 1,\n  \"b\": x\n}", ":3: invalid character 'x'"},
+	{".json", "{\n  \"a\": 1,\n", ":3: unexpected end of JSON input"},
+	{".json", "{\n  \"a\": [1,\n", ":3: unexpected end of JSON input"},
+	{".json", "\n[1]", ":2: top level should be an object"},
+	{".json", "{\"a\": 1,\n\"a\": 2}", ":2: duplicate key 'a'"},
+	{".ini", "a = 1", "unknown format"},
+}
+
+func TestConfigFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "websocketd-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, tt := range configErrorTests {
+		_, err := loadConfigFile(writeConfigFile(t, dir, tt.ext, tt.data))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s %q: expected error containing %q, got %v", tt.ext, tt.data, tt.err, err)
+		}
+	}
+}
+
+func TestApplyConfigFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "websocketd-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	environ := []string{"PATH=/bin:/usr/bin"}
+
+	path := writeConfigFile(t, dir, ".yaml", "port: 8080\nmaxforks: 3\nheader: [\"A: 1\", \"B: 2\"]\ncommand: cat -u\n")
+	config, err := parseConfig([]string{"--config=" + path, "--maxforks=5"}, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.MaxForks != 5 {
+		t.Errorf("command line should win over config file, got maxforks %d", config.MaxForks)
+	}
+	if len(config.Headers) != 2 || config.Headers[1] != "B: 2" {
+		t.Errorf("every list item should be passed as repeated flag, got %q", config.Headers)
+	}
+	if !strings.HasSuffix(config.CommandName, "/cat") || len(config.CommandArgs) != 1 || config.CommandArgs[0] != "-u" {
+		t.Errorf("command string should be split at spaces, got %s %q", config.CommandName, config.CommandArgs)
+	}
+
+	path = writeConfigFile(t, dir, ".toml", "port = 80\nconfig = \"x\"\nbogus = 1\nmaxforks = [\"a\"]\nheader = { a = 1 }\n")
+	_, err = parseConfig([]string{"--config=" + path, "cat"}, environ)
+	if err == nil {
+		t.Fatal("bad keys were accepted")
+	}
+	for _, problem := range []string{":2: unknown key 'config'", ":3: unknown key 'bogus'", ":4: invalid value \"a\" for maxforks", ":5: header: value should not be a table"} {
+		if !strings.Contains(err.Error(), path+problem) {
+			t.Errorf("error %q should mention %q", err, problem)
+		}
+	}
+}
+
+func TestPrintConfigRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "websocketd-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	environ := []string{"PATH=/bin:/usr/bin"}
+
+	config, err := parseConfig([]string{"--print-config", "--port=8080", "--header=X-A: b \"q\" # not a comment",
+		"--drain-closereason=- dash", "--origin=a.com,b.com", "--setenv=A=it's", "--route=/chat/{room}=cat -n",
+		"--stderrprefix=: colon", "--header=B: [x]", "cat", "-u"}, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	printed := config.PrintConfig
+
+	path := writeConfigFile(t, dir, ".yaml", printed)
+	config, err = parseConfig([]string{"--config=" + path, "--print-config"}, environ)
+	if err != nil {
+		t.Fatalf("printed configuration could not be read back: %s\n%s", err, printed)
+	}
+	if config.PrintConfig != printed {
+		t.Errorf("configuration changed after round trip:\n%s\nvs\n%s", printed, config.PrintConfig)
+	}
+	if len(config.Headers) != 2 || config.Headers[0] != "X-A: b \"q\" # not a comment" || config.Headers[1] != "B: [x]" {
+		t.Errorf("headers were not read back: %q", config.Headers)
+	}
+	if len(config.Routes) != 1 || config.Routes[0].Path != "/chat/{room}" {
+		t.Errorf("routes were not read back: %v", config.Routes)
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var envKeyRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// envBuilder collects variables given by --envfile, --setenv and routes. Values
+// could refer to variables defined before them or to websocketd own environment
+// as ${VAR} or ${VAR:-default}.
+type envBuilder struct {
+	parent []string // environment websocketd was started with
+	keys   []string
+	values map[string]string
+}
+
+func newEnvBuilder(parent []string) *envBuilder {
+	return &envBuilder{parent: parent, values: make(map[string]string)}
+}
+
+func (b *envBuilder) lookup(key string) (string, bool) {
+	if v, ok := b.values[key]; ok {
+		return v, true
+	}
+	for i := len(b.parent) - 1; i >= 0; i-- {
+		if strings.HasPrefix(b.parent[i], key+"=") {
+			return b.parent[i][len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+// set defines variable, later definitions replace earlier ones.
+func (b *envBuilder) set(key, value string) {
+	if _, ok := b.values[key]; !ok {
+		b.keys = append(b.keys, key)
+	}
+	b.values[key] = value
+}
+
+// assign reads "KEY=value" expanding references in value.
+func (b *envBuilder) assign(s string) error {
+	p := strings.IndexByte(s, '=')
+	if p <= 0 || !envKeyRe.MatchString(s[:p]) {
+		return fmt.Errorf("'%s' should look like KEY=value", s)
+	}
+	b.set(s[:p], b.expand(s[p+1:]))
+	return nil
+}
+
+// expand replaces ${VAR} and ${VAR:-default} references, undefined variables
+// become empty strings. Any other $ is left as it is.
+func (b *envBuilder) expand(s string) string {
+	var out strings.Builder
+	for {
+		start := strings.Index(s, "${")
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(s[start:], '}')
+		if end < 0 {
+			break
+		}
+		out.WriteString(s[:start])
+		ref := s[start+2 : start+end]
+		name, def, hasDefault := ref, "", false
+		if p := strings.Index(ref, ":-"); p >= 0 {
+			name, def, hasDefault = ref[:p], ref[p+2:], true
+		}
+		if v, ok := b.lookup(name); ok && (v != "" || !hasDefault) {
+			out.WriteString(v)
+		} else {
+			out.WriteString(def)
+		}
+		s = s[start+end+1:]
+	}
+	out.WriteString(s)
+	return out.String()
+}
+
+// loadFile reads dotenv file: KEY=value lines, optionally prefixed by "export",
+// with # comments. Values in single quotes are taken literally, double quoted
+// ones understand \n, \t, \", \\ and \$ escapes and, as unquoted ones, ${VAR}
+// references.
+func (b *envBuilder) loadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for num := 1; scanner.Scan(); num++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimPrefix(line, "export "))
+
+		p := strings.IndexByte(line, '=')
+		if p <= 0 || !envKeyRe.MatchString(strings.TrimSpace(line[:p])) {
+			return fmt.Errorf("%s:%d: expected KEY=value", path, num)
+		}
+		key, raw := strings.TrimSpace(line[:p]), strings.TrimSpace(line[p+1:])
+
+		value, err := b.dotenvValue(raw)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s", path, num, err)
+		}
+		b.set(key, value)
+	}
+	return scanner.Err()
+}
+
+func (b *envBuilder) dotenvValue(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	quote := raw[0]
+	if quote != '"' && quote != '\'' {
+		if p := strings.Index(raw, " #"); p >= 0 {
+			raw = strings.TrimSpace(raw[:p])
+		}
+		return b.expand(raw), nil
+	}
+
+	var value strings.Builder
+	for i := 1; i < len(raw); i++ {
+		c := raw[i]
+		if c == quote {
+			if rest := strings.TrimSpace(raw[i+1:]); rest != "" && rest[0] != '#' {
+				return "", fmt.Errorf("unexpected %s after quoted value", rest)
+			}
+			return value.String(), nil
+		}
+		if c == '\\' && quote == '"' && i+1 < len(raw) {
+			i++
+			switch raw[i] {
+			case 'n':
+				c = '\n'
+			case 't':
+				c = '\t'
+			default:
+				c = raw[i]
+			}
+		} else if c == '$' && quote == '"' && strings.HasPrefix(raw[i:], "${") {
+			// references are expanded as they come, so escaped \$ stays literal
+			if end := strings.IndexByte(raw[i:], '}'); end > 0 && strings.IndexByte(raw[i:i+end], quote) < 0 {
+				value.WriteString(b.expand(raw[i : i+end+1]))
+				i += end
+				continue
+			}
+		}
+		value.WriteByte(c)
+	}
+	return "", fmt.Errorf("quoted value is not terminated")
+}
+
+// list returns variables as KEY=value strings.
+func (b *envBuilder) list() []string {
+	env := make([]string, 0, len(b.keys))
+	for _, k := range b.keys {
+		env = append(env, k+"="+b.values[k])
+	}
+	return env
+}
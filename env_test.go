@@ -0,0 +1,64 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestEnvFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "websocketd-env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".env")
+	err = ioutil.WriteFile(path, []byte(`# comment
+HOME=/override
+export PLAIN=${HOME}/x # comment
+SINGLE='${HOME} \n $'
+DOUBLE="${HOME}\t\"q\" \${HOME} $5 \\${HOME}"
+DEFAULT="${MISSING:-none}"
+ESCAPED="${HOME}\n"
+`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := newEnvBuilder([]string{"HOME=/home/joe"})
+	if err := b.loadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.assign("SET=${PLAIN}:$"); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"HOME=/override",
+		"PLAIN=/override/x",
+		`SINGLE=${HOME} \n $`,
+		"DOUBLE=/override\t\"q\" ${HOME} $5 \\/override",
+		"DEFAULT=none",
+		"ESCAPED=/override\n",
+		"SET=/override/x:$",
+	}
+	if got := b.list(); strings.Join(got, "|") != strings.Join(expected, "|") {
+		t.Errorf("got\n%q\nexpected\n%q", got, expected)
+	}
+
+	for _, bad := range []string{`A="open`, `A="x" y`, `1A=x`, `just text`} {
+		if err := ioutil.WriteFile(path, []byte("\n"+bad+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := newEnvBuilder(nil).loadFile(path); err == nil || !strings.Contains(err.Error(), ":2: ") {
+			t.Errorf("%s: expected error on line 2, got %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Listening sockets are handed over to the new process the same way systemd does
+// it: as file descriptors starting from 3, described by environment variables.
+const (
+	inheritFdsEnv   = "WEBSOCKETD_INHERIT_FDS"
+	inheritKindsEnv = "WEBSOCKETD_INHERIT_KINDS"
+	handoffReadyEnv = "WEBSOCKETD_HANDOFF_READY_FD"
+
+	websocketKind  = "websocket"
+	redirectKind   = "redirect="
+	metricsKind    = "metrics"
+	adminKind      = "admin"
+	handoffTimeout = 10 * time.Second
+)
+
+// inheritEnvs describe sockets passed to this process, they are dropped once
+// read and replaced when sockets are handed over.
+var inheritEnvs = []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES", inheritFdsEnv, inheritKindsEnv, handoffReadyEnv}
+
+// handoff starts new websocketd process from executable (which might have been
+// upgraded since this one started) with the same arguments and passes listening
+// sockets to it. It returns nil once the new process reports that it's serving,
+// after that this process should stop accepting connections.
+func handoff(executable string, environ []string, listeners []*listener) error {
+	files := make([]*os.File, 0, len(listeners)+1)
+	closeFiles := func() {
+		for _, f := range files {
+			f.Close()
+		}
+		files = nil
+	}
+	defer closeFiles()
+
+	kinds := make([]string, 0, len(listeners))
+	for _, l := range listeners {
+		fl, ok := l.Listener.(interface {
+			File() (*os.File, error)
+		})
+		if !ok {
+			return fmt.Errorf("socket %s could not be handed over", l.name)
+		}
+		f, err := fl.File()
+		if err != nil {
+			return fmt.Errorf("socket %s could not be handed over: %s", l.name, err)
+		}
+		files = append(files, f)
+		kinds = append(kinds, l.kind())
+	}
+
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	files = append(files, readyW)
+
+	cmd := &exec.Cmd{
+		Path:       executable,
+		Args:       os.Args,
+		Env:        handoffEnv(environ, kinds),
+		Stdout:     os.Stdout,
+		Stderr:     os.Stderr,
+		ExtraFiles: files,
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	closeFiles() // new process has its own copies, EOF on ready pipe means it's gone
+	go cmd.Wait()
+
+	result := make(chan error, 1)
+	go func() {
+		if _, err := ready.Read(make([]byte, 1)); err != nil {
+			result <- fmt.Errorf("new process (pid %d) exited before it was ready", cmd.Process.Pid)
+		} else {
+			result <- nil
+		}
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-time.After(handoffTimeout):
+		cmd.Process.Kill()
+		return fmt.Errorf("new process (pid %d) was not ready in %s", cmd.Process.Pid, handoffTimeout)
+	}
+}
+
+// kind describes listener to process it's handed over to.
+func (l *listener) kind() string {
+	if l.redirect != "" {
+		return redirectKind + l.redirect
+	}
+	if l.service != "" {
+		return l.service
+	}
+	return websocketKind
+}
+
+// handoffEnv returns environ for process sockets of given kinds are handed
+// over to, the handoff ready pipe follows them. Other variables (such as
+// WEBSOCKETD_ADMIN_TOKEN) are kept as new process reads its configuration again.
+func handoffEnv(environ []string, kinds []string) []string {
+	env := make([]string, 0, len(environ)+3)
+	for _, kv := range environ {
+		if !isInheritEnv(kv) {
+			env = append(env, kv)
+		}
+	}
+	return append(env,
+		inheritFdsEnv+"="+strconv.Itoa(len(kinds)),
+		inheritKindsEnv+"="+strings.Join(kinds, ":"),
+		handoffReadyEnv+"="+strconv.Itoa(listenFdsStart+len(kinds)))
+}
+
+func isInheritEnv(kv string) bool {
+	for _, key := range inheritEnvs {
+		if strings.HasPrefix(kv, key+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// notifyReady tells previous websocketd process which handed sockets over that
+// this one is serving now.
+func notifyReady(fd int) {
+	f := os.NewFile(uintptr(fd), "handoff-ready")
+	f.Write([]byte{1})
+	f.Close()
+}
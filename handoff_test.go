@@ -0,0 +1,51 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestListenerKind(t *testing.T) {
+	var tests = []struct {
+		l    *listener
+		kind string
+	}{
+		{&listener{}, websocketKind},
+		{&listener{redirect: "443"}, "redirect=443"},
+		{&listener{service: metricsKind}, metricsKind},
+		{&listener{service: adminKind}, adminKind},
+	}
+	for _, tt := range tests {
+		if got := tt.l.kind(); got != tt.kind {
+			t.Errorf("%+v: got kind %s, expected %s", tt.l, got, tt.kind)
+		}
+	}
+}
+
+func TestHandoffEnv(t *testing.T) {
+	environ := []string{"PATH=/bin:/usr/bin", adminTokenEnv + "=secret", "LISTEN_FDS=2", "LISTEN_PID=1",
+		inheritFdsEnv + "=1", inheritKindsEnv + "=websocket", handoffReadyEnv + "=4", "WEBSOCKETD_OTHER=x"}
+	env := handoffEnv(environ, []string{websocketKind, "redirect=443", adminKind})
+
+	expected := []string{"PATH=/bin:/usr/bin", adminTokenEnv + "=secret", "WEBSOCKETD_OTHER=x",
+		inheritFdsEnv + "=3", inheritKindsEnv + "=websocket:redirect=443:admin", handoffReadyEnv + "=6"}
+	if strings.Join(env, "|") != strings.Join(expected, "|") {
+		t.Errorf("got\n%q\nexpected\n%q", env, expected)
+	}
+
+	// new process reads its configuration from the same arguments again
+	config, err := parseConfig([]string{"--adminaddr=127.0.0.1:0", "cat"}, env)
+	if err != nil {
+		t.Fatalf("new process could not start: %s", err)
+	}
+	if config.AdminToken != "secret" || config.InheritedFDs != 3 || config.ReadyFD != 6 ||
+		strings.Join(config.InheritedKinds, " ") != "websocket redirect=443 admin" {
+		t.Errorf("handed over configuration was not read back: token %q, fds %d, ready %d, kinds %q",
+			config.AdminToken, config.InheritedFDs, config.ReadyFD, config.InheritedKinds)
+	}
+}
@@ -28,14 +28,116 @@ Usage:
   Or, export an entire directory of executables as WebSocket endpoints:
     {{binary}} [options] --dir=SOMEDIR
 
+  Or, replay session recorded with --record to test the program:
+    {{binary}} replay [replay options] FILE [COMMAND [command args]]
+
+  Or, export different programs on different URL paths:
+    {{binary}} [options] --route=/PATH=COMMAND [--route=...]
+
 Options:
 
+  --config=FILE                  Read options from configuration file (.yaml,
+                                 .toml or .json). Keys are option names without
+                                 dashes in front, repeated options are lists,
+                                 "command" is COMMAND with its arguments.
+                                 Options given on command line take precedence.
+                                 Only a subset of YAML and TOML is understood:
+                                 no YAML flow mappings, block scalars, anchors
+                                 or tags, and no TOML tables other than
+                                 [[routes]], dotted keys or multi-line strings.
+
+  --print-config                 Print effective configuration (options merged
+                                 with configuration file) as YAML and exit.
+
   --port=PORT                    HTTP port to listen on.
 
   --address=ADDRESS              Address to bind to (multiple options allowed)
                                  Use square brackets to specify IPv6 address.
+                                 Use unix:/path/to/socket for unix domain socket.
                                  Default: "" (all)
 
+  --unixmode=MODE                Permissions of unix domain sockets in octal
+                                 (e.g. 0660). Default: "" (as umask allows)
+
+  --unixowner=USER[:GROUP]       Owner and group of unix domain sockets.
+                                 Default: "" (user running websocketd)
+
+                                 Sockets passed by systemd socket activation
+                                 (LISTEN_FDS) are used automatically, --port and
+                                 --address are only needed to add more sockets.
+
+  --redirport=PORT               Open alternative port and redirect HTTP traffic
+                                 from it to canonical address (mostly useful
+                                 for HTTPS-only configurations to redirect HTTP
+                                 traffic)
+
+  --metrics=PATH                 Serve Prometheus metrics (sessions, forks,
+                                 upgrades, process exits, traffic and session
+                                 durations) on this URL path.
+
+  --metricsaddr=HOST:PORT        Serve metrics on separate address instead,
+                                 on --metrics path or /metrics.
+
+  --healthz=PATH                 URL path answered with 200 for as long as
+                                 server runs, e.g. /healthz. Disabled unless
+                                 set.
+
+  --readyz=PATH                  URL path answered with 200 when server takes
+                                 new connections and 503 once it is shutting
+                                 down, --readyz-forks of --maxforks are in use
+                                 or --readyz-probe fails, e.g. /readyz.
+                                 Disabled unless set.
+  --readyz-forks=PERCENT         Default: 90
+  --readyz-probe="COMMAND ARGS"  Command that should exit with 0 for server to
+                                 be ready, arguments are split on spaces. The
+                                 command is looked up in PATH on start. Its
+                                 result is reused for a second.
+  --readyz-timeout=DURATION      Time given to --readyz-probe. Default: 2s
+
+  --adminaddr=HOST:PORT          Serve admin API on this address. It lists live
+                                 sessions (GET /sessions), shows one with its
+                                 environment (GET /sessions/ID) and terminates
+                                 them (DELETE /sessions/ID?code=1008&reason=).
+                                 It is served over plain HTTP, so bind it to
+                                 localhost or private network, or put TLS
+                                 terminating proxy in front of it.
+  --admintoken-file=FILE         Token admin API requests should present as
+                                 "Authorization: Bearer TOKEN", read from FILE.
+                                 It could also be passed in
+                                 WEBSOCKETD_ADMIN_TOKEN environment variable.
+                                 Required with --adminaddr.
+  --admintoken=TOKEN             Same as --admintoken-file, but the token is
+                                 visible to anyone who could list processes.
+
+  --ssl                          Listen for HTTPS socket instead of HTTP.
+  --sslcert=FILE                 All three options must be used or all of
+  --sslkey=FILE                  them should be omitted.
+
+  --sslminversion=VERSION        Minimal TLS protocol version accepted when
+                                 --ssl is used, one of: 1.0, 1.1, 1.2, 1.3.
+                                 Default: 1.2
+
+  --sslciphers=NAME[,NAME...]    Restrict TLS 1.0-1.2 cipher suites to the
+                                 listed ones (names as in Go crypto/tls, e.g.
+                                 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256).
+                                 Default: "" (Go defaults)
+
+  --sslclientca=FILE             Verify client certificates against the CA
+                                 bundle. Verified identity is passed to the
+                                 process as SSL_CLIENT_* variables.
+
+  --sslclientauth=MODE           Client certificate policy, one of: none,
+                                 optional, require.
+                                 Default: require when --sslclientca is given
+
+  --sslcrl=FILE                  Reject client certificates listed in the
+                                 certificate revocation list (PEM or DER).
+                                 PEM file could hold CRLs of several CAs.
+                                 Expired CRL is refused, and once a loaded
+                                 one expires, certificates of its CA are
+                                 rejected until websocketd is restarted or
+                                 handed off (SIGUSR2) with updated file.
+
   --sameorigin={true,false}      Restrict (HTTP 403) protocol upgrades if the
                                  Origin header does not match to requested HTTP
                                  Host. Default: false.
@@ -51,6 +153,67 @@ Options:
                                  passed to executed scripts. Does not work for
                                  Windows since all the variables are kept there.
 
+  --queryenv                     Pass every decoded query string parameter to
+                                 the process as QUERY_<NAME> variable. Names are
+                                 uppercased, characters other than letters,
+                                 digits and _ become _. Repeated parameters also
+                                 get QUERY_<NAME>_COUNT and QUERY_<NAME>_1...N.
+                                 Parameters that would replace other variables
+                                 (e.g. "string" as QUERY_STRING) are dropped.
+
+  --cookieenv                    Same as --queryenv for cookies, which are passed
+                                 as COOKIE_<NAME> variables.
+
+  --parsedenvlimit=BYTES         Limit total size of QUERY_* and COOKIE_*
+                                 variables, the ones that do not fit are
+                                 dropped. Default: 16384
+
+  --setenv=KEY=VALUE             Pass KEY variable to the process. VALUE could
+                                 refer to websocketd environment or to variables
+                                 defined earlier as ${VAR} or ${VAR:-default}.
+                                 Could be given multiple times.
+
+  --envfile=FILE                 Read variables for the process from dotenv
+                                 FILE: KEY=VALUE lines, optionally prefixed with
+                                 "export", # comments, 'literal' and "quoted"
+                                 values with ${VAR} references (\$ is literal
+                                 $). --setenv variables override ones from the
+                                 file.
+
+  --dir=SOMEDIR                  Allow all scripts in the local directory
+                                 to be accessed as WebSockets. If using this
+                                 option, then the standard program and args
+                                 options should not be specified.
+
+  --route=/PATH=COMMAND [ARGS]   Serve WebSocket upgrades for URL path with its
+                                 own command (multiple options allowed). PATH
+                                 ending with / matches everything below it, *
+                                 matches any single path segment, the most
+                                 specific route wins. {name} segment is passed
+                                 to COMMAND as PATH_PARAM_NAME variable, it
+                                 could be restricted with {name:int},
+                                 {name:uuid} or {name:REGEXP} (HTTP 404 when
+                                 the segment does not fit). COMMAND or --dir serve
+                                 paths no route matches. Routes could also be
+                                 given in --config file as "routes" list of
+                                 tables with path, command, binary, maxforks,
+                                 closems, origin, sameorigin and env keys.
+
+  --staticdir=SOMEDIR            Serve static content from this directory over
+                                 HTTP (index.html is used for directories).
+
+  --cgidir=SOMEDIR               Serve CGI scripts from this directory over
+                                 HTTP. Requests that are not WebSocket upgrades
+                                 are answered by executable found in this dir
+                                 (CGI processes count towards --maxforks).
+
+  --staticlisting={true,false}   List files of --staticdir directories that do
+                                 not have index.html. Default: false
+
+  --devconsole                   Enable development console. This cannot be
+                                 used in conjunction with --staticdir or
+                                 --cgidir.
+
   --binary={true,false}          Switches communication to binary, process reads
                                  send to browser as blobs and all reads from the
                                  browser are immediately flushed to the process.
@@ -71,9 +234,29 @@ Options:
                                  to it. Default: 0 (signals sent after 100ms, 250ms,
                                  and 500ms of waiting)
 
+  --drain-timeout=DURATION       On SIGTERM or SIGINT websocketd stops accepting
+                                 connections, asks clients to close and
+                                 terminates processes. It exits when all sessions
+                                 are finished or after this timeout.
+                                 Default: 5s
+
+  --drain-closecode=CODE         WebSocket close code sent to clients on
+                                 shutdown. Default: 1001 (Going Away)
+
+  --drain-closereason="..."      WebSocket close reason sent to clients on
+                                 shutdown. Default: "" (none)
+
   --header="..."                 Set custom HTTP header to each answer. For
                                  example: --header="Server: someserver/0.0.1"
 
+  --header-ws="...."             Same as --header, just applies to only those
+                                 responses that indicate upgrade of TCP connection
+                                 to a WebSockets protocol.
+
+  --header-http="...."           Same as --header, just applies to only to plain
+                                 HTTP responses that do not indicate WebSockets
+                                 upgrade.
+
   --help                         Print help and exit.
 
   --version                      Print version and exit.
@@ -84,10 +267,94 @@ Options:
                                  From most to least verbose:
                                  debug, trace, access, info, error, fatal
 
+  --stderrlevel=LEVEL            Level stderr lines of processes are logged at,
+                                 "none" drops them. Default: error
+  --stderrcategory=NAME          Log category of stderr lines. Default: stderr
+  --stderrlinelimit=BYTES        Longer stderr lines are cut. Default: 4096
+  --stderrforward=MODE           Send stderr lines to the client as well, for
+                                 debugging. MODE "binary" sends them as binary
+                                 messages (not with --binary), "prefix" as
+                                 regular messages with --stderrprefix.
+  --stderrprefix=STRING          Default: "stderr: "
+
+  --record=DIR                   Write transcript of every session to DIR as
+                                 ID.jsonl (ID is the one sessions are logged
+                                 with): process environment, every message
+                                 with time and direction, close code and
+                                 exit status. Format is described in
+                                 libwebsocketd.RecordEntry docs, see
+                                 '{{binary}} replay --help' for replaying.
+  --recordlimit=MB               Stop recording messages once transcript
+                                 grows past MB megabytes, 0 means no limit.
+                                 Default: 10
+
+  --logformat=FORMAT             Log line format: text (default), json or
+                                 logfmt. Last two carry time (RFC3339 with
+                                 nanoseconds), level, category, msg and every
+                                 associated value (id, remote, url, pid,
+                                 origin...) as separate fields.
+
+  --log=DEST                     Where log goes: stdout (default), local syslog
+                                 as RFC 3164 messages with associated values
+                                 appended as key=value (syslog or
+                                 syslog:FACILITY, daemon by default, local0-7,
+                                 user...) or journald with WEBSOCKETD_<KEY>
+                                 fields. Messages over 128KB are truncated
+                                 for journald.
+
+  --logfile=FILE                 Write log to FILE instead of stdout. It is
+                                 reopened on SIGUSR1 for external rotation.
+  --logmaxsize=MB                Rotate FILE once it grows past MB megabytes,
+                                 rotated files get timestamp suffix.
+  --logmaxage=DURATION           Rotate FILE once it was written for DURATION.
+  --logmaxfiles=N                Keep only N latest rotated files.
+  --logcompress                  Gzip rotated files.
+
+Signals:
+
+  SIGTERM, SIGINT                Drain sessions and exit (see --drain-timeout).
+
+  SIGHUP                         Re-read configuration (including --config
+                                 file). Origins, headers, passenv, maxforks
+                                 and other options that do not need sockets
+                                 to be reopened are applied to new connections.
+
+  SIGUSR2                        Start {{binary}} found at the same path again
+                                 and hand listening sockets over to it. This
+                                 process stops accepting connections and exits
+                                 once its active sessions are finished.
+
+  SIGUSR1                        Reopen --logfile.
+
 Full documentation at http://websocketd.com/
 
 Copyright 2013 Joe Walnes and the websocketd team. All rights reserved.
 BSD license: Run '{{binary}} --license' for details.
+`
+	replayHelp = `
+Usage:
+
+  {{binary}} replay [options] FILE [COMMAND [command args]]
+
+Starts COMMAND (the recorded one by default) with environment from FILE
+written by --record, sends it messages the client sent and compares what it
+answers with the recorded messages. Differences are printed like a diff,
+"-" lines were expected and "+" ones were sent instead. Exit code is 0 if
+everything matched, 1 if not and 2 if replay could not be done.
+
+Session ends as it did when recorded: once the process finishes or, if
+client or server closed it, after the expected number of messages arrived;
+the process is then stopped the same way websocketd does it.
+
+Options:
+
+  --fast                         Send messages as fast as possible instead of
+                                 keeping original timing.
+  --timeout=DURATION             Time to wait for output once all messages
+                                 were sent. Default: 5s
+  --loglevel=LEVEL               Level of log messages about the process
+                                 (stderr lines etc.) written to stderr.
+                                 Default: error
 `
 	short = `
 Usage:
@@ -0,0 +1,154 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	adminSessionsPath = "/sessions"
+	adminCloseCode    = websocket.ClosePolicyViolation // sent when code is not given
+)
+
+// sessionInfo is how session is shown by admin API
+type sessionInfo struct {
+	Id       string    `json:"id"`
+	Remote   string    `json:"remote"`
+	URL      string    `json:"url"`
+	Command  string    `json:"command"`
+	Pid      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+	BytesIn  int64     `json:"bytes_in"`
+	BytesOut int64     `json:"bytes_out"`
+	Env      []string  `json:"env,omitempty"`
+}
+
+// terminationInfo is the answer to session termination request
+type terminationInfo struct {
+	Id       string `json:"id"`
+	Stage    string `json:"stage"`
+	ExitCode int    `json:"exit_code"`
+}
+
+func (s *session) info(withEnv bool) *sessionInfo {
+	info := &sessionInfo{
+		Id:       s.handler.Id,
+		Remote:   s.handler.RemoteInfo.Addr,
+		URL:      s.handler.url,
+		Command:  s.handler.command,
+		Pid:      s.process.process.cmd.Process.Pid,
+		Started:  s.started,
+		BytesIn:  atomic.LoadInt64(&s.bytesIn),
+		BytesOut: atomic.LoadInt64(&s.bytesOut),
+	}
+	if withEnv {
+		info.Env = s.handler.Env
+	}
+	return info
+}
+
+// AdminHandler serves API to look at live sessions and terminate them. Every
+// request has to carry "Authorization: Bearer <token>" header.
+//
+//	GET    /sessions       list of sessions
+//	GET    /sessions/ID    session with its environment
+//	DELETE /sessions/ID    send close frame (?code=&reason=, 1008 by default)
+//	                       to the client and terminate the process
+func (h *WebsocketdServer) AdminHandler(token string) http.Handler {
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		log := h.Log.NewLevel(h.Log.LogFunc)
+		log.Associate("admin", req.Method+" "+req.URL.Path)
+		log.Associate("remote", req.RemoteAddr)
+
+		if token == "" || subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), expected) != 1 {
+			log.Access("admin", "UNAUTHORIZED")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="websocketd"`)
+			http.Error(w, "401 Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.serveAdmin(w, req, log)
+	})
+}
+
+func (h *WebsocketdServer) serveAdmin(w http.ResponseWriter, req *http.Request, log *LogScope) {
+	path := strings.TrimSuffix(req.URL.Path, "/")
+	if path == adminSessionsPath {
+		if req.Method != "GET" {
+			http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		live := h.liveSessions()
+		infos := make([]*sessionInfo, len(live))
+		for i, s := range live {
+			infos[i] = s.info(false)
+		}
+		writeJSON(w, http.StatusOK, infos)
+		return
+	}
+
+	if !strings.HasPrefix(path, adminSessionsPath+"/") {
+		http.NotFound(w, req)
+		return
+	}
+	id := path[len(adminSessionsPath)+1:]
+	s := h.findSession(id)
+	if s == nil {
+		http.Error(w, "404 session not found", http.StatusNotFound)
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		writeJSON(w, http.StatusOK, s.info(true))
+	case "DELETE":
+		code := adminCloseCode
+		if c := req.FormValue("code"); c != "" {
+			var err error
+			if code, err = strconv.Atoi(c); err != nil || !validCloseCode(code) {
+				http.Error(w, "400 close code should be 1000-4999 and allowed to be sent", http.StatusBadRequest)
+				return
+			}
+		}
+		reason := req.FormValue("reason")
+		if len(reason) > 123 {
+			http.Error(w, "400 close reason should be up to 123 bytes", http.StatusBadRequest)
+			return
+		}
+		log.Access("admin", "Terminating session %s with close code %d", id, code)
+		s.close(code, reason)
+		writeJSON(w, http.StatusOK, &terminationInfo{id, s.process.stage, s.process.exitCode})
+	default:
+		http.Error(w, "405 Method Not Allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// validCloseCode tells if code could be sent in close frame, 1004-1006 and 1015
+// are reserved for local use.
+func validCloseCode(code int) bool {
+	switch code {
+	case 1004, 1005, 1006, 1015:
+		return false
+	}
+	return code >= 1000 && code <= 4999
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
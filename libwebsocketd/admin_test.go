@@ -0,0 +1,51 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	h := NewWebsocketdServer(&Config{}, silentLog(), 0)
+	admin := h.AdminHandler("token")
+
+	var tests = []struct {
+		method, path, auth string
+		status             int
+	}{
+		{"GET", "/sessions", "", http.StatusUnauthorized},
+		{"GET", "/sessions", "Bearer wrong", http.StatusUnauthorized},
+		{"GET", "/sessions", "Bearer token", http.StatusOK},
+		{"POST", "/sessions", "Bearer token", http.StatusMethodNotAllowed},
+		{"GET", "/sessions/123", "Bearer token", http.StatusNotFound},
+		{"DELETE", "/sessions/123", "Bearer token", http.StatusNotFound},
+		{"GET", "/other", "Bearer token", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		hdrs := map[string]string{}
+		if tt.auth != "" {
+			hdrs["Authorization"] = tt.auth
+		}
+		rec := serve(admin, tt.method, tt.path, hdrs)
+		if rec.Code != tt.status {
+			t.Errorf("%s %s with %q: got status %d, want %d", tt.method, tt.path, tt.auth, rec.Code, tt.status)
+		}
+		if rec.Code == http.StatusOK && strings.TrimSpace(rec.Body.String()) != "[]" {
+			t.Errorf("%s %s: expected empty list, got %s", tt.method, tt.path, rec.Body.String())
+		}
+	}
+}
+
+func TestValidCloseCode(t *testing.T) {
+	for code, valid := range map[int]bool{999: false, 1000: true, 1006: false, 1008: true, 1015: false, 4999: true, 5000: false} {
+		if validCloseCode(code) != valid {
+			t.Errorf("validCloseCode(%d) should be %t", code, valid)
+		}
+	}
+}
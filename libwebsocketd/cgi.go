@@ -0,0 +1,116 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+const (
+	cgiGatewayInterface = "CGI/1.1"
+)
+
+// serveCGI runs script from Config.CgiDir as classic RFC 3875 CGI program, request
+// body is streamed to its STDIN and its STDOUT is parsed as CGI response.
+func (h *WebsocketdServer) serveCGI(w http.ResponseWriter, req *http.Request, config *Config, urlInfo *URLInfo, log *LogScope) {
+	handler := &WebsocketdHandler{server: h, config: config, Id: generateId(), URLInfo: urlInfo, command: urlInfo.FilePath}
+	log.Associate("id", handler.Id)
+
+	var err error
+	handler.RemoteInfo, err = GetRemoteInfo(req.RemoteAddr, config.ReverseLookup)
+	if err != nil {
+		log.Error("session", "Could not understand remote address '%s': %s", req.RemoteAddr, err)
+		http.Error(w, "500 Internal Server Error", 500)
+		return
+	}
+	log.Associate("remote", handler.RemoteInfo.Host)
+	log.Associate("cgiscript", handler.command)
+
+	handler.Env = createEnv(handler, req, cgiGatewayInterface, log)
+
+	launched, err := launchCmd(handler.command, nil, handler.Env)
+	if err != nil {
+		log.Error("process", "Could not launch CGI script %s (%s)", handler.command, err)
+		h.metrics.spawnFailed()
+		http.Error(w, "500 Internal Server Error", 500)
+		return
+	}
+	log.Associate("pid", strconv.Itoa(launched.cmd.Process.Pid))
+	log.Access("http", "CGI")
+
+	go func() {
+		if req.Body != nil {
+			if _, err := io.Copy(launched.stdin, req.Body); err != nil {
+				log.Debug("process", "Could not pass request body to CGI script: %s", err)
+			}
+		}
+		launched.stdin.Close()
+	}()
+	go newStderrHandling(config).read(launched.stderr, log)
+
+	defer func() {
+		if err := launched.cmd.Wait(); err != nil {
+			log.Debug("process", "CGI script finished with: %s", err)
+		}
+	}()
+
+	stdout := bufio.NewReader(launched.stdout)
+	status, err := readCGIHeaders(stdout, w.Header())
+	if err != nil {
+		log.Error("process", "Invalid CGI response: %s", err)
+		http.Error(w, "500 Internal Server Error", 500)
+		io.Copy(ioutil.Discard, stdout) // let the script finish writing
+		return
+	}
+
+	w.WriteHeader(status)
+	if _, err := io.Copy(w, stdout); err != nil {
+		log.Debug("process", "Could not pass CGI response to client: %s", err)
+		io.Copy(ioutil.Discard, stdout)
+	}
+}
+
+// readCGIHeaders parses header block of CGI response into h and figures out HTTP
+// status code to answer with.
+func readCGIHeaders(r *bufio.Reader, h http.Header) (int, error) {
+	hdrs, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil && !(err == io.EOF && len(hdrs) > 0) {
+		return 0, fmt.Errorf("cannot read headers: %s", err)
+	}
+
+	status := http.StatusOK
+	if s := hdrs.Get("Status"); s != "" {
+		code := s
+		if p := strings.IndexByte(s, ' '); p > 0 {
+			code = s[:p]
+		}
+		status, err = strconv.Atoi(code)
+		if err != nil || status < 100 || status > 999 {
+			return 0, fmt.Errorf("bad Status header %#v", s)
+		}
+		hdrs.Del("Status")
+	} else if hdrs.Get("Location") != "" {
+		status = http.StatusFound
+	}
+
+	if hdrs.Get("Content-Type") == "" && hdrs.Get("Location") == "" {
+		return 0, fmt.Errorf("neither Content-Type nor Location header was returned")
+	}
+
+	for k, vals := range hdrs {
+		for _, v := range vals {
+			h.Add(k, v)
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,84 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var cgiHeaderTests = []struct {
+	output   string
+	status   int
+	location string
+	body     string
+}{
+	{"Content-Type: text/plain\n\nhello", 200, "", "hello"},
+	{"Content-Type: text/plain\r\n\r\nhello", 200, "", "hello"},
+	{"Status: 404 Not Found\nContent-Type: text/plain\n\ngone", 404, "", "gone"},
+	{"Location: http://example.com/\n\n", 302, "http://example.com/", ""},
+	{"Status: 301\nLocation: /elsewhere\n\n", 301, "/elsewhere", ""},
+	{"Status: teapot\nContent-Type: text/plain\n\n", 0, "", ""},
+	{"X-Nothing: here\n\nbody", 0, "", ""},
+}
+
+func TestReadCGIHeaders(t *testing.T) {
+	for _, testcase := range cgiHeaderTests {
+		r := bufio.NewReader(strings.NewReader(testcase.output))
+		h := make(http.Header)
+		status, err := readCGIHeaders(r, h)
+		if testcase.status == 0 {
+			if err == nil {
+				t.Errorf("output %#v should be rejected", testcase.output)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("output %#v failed: %s", testcase.output, err)
+			continue
+		}
+		if status != testcase.status || h.Get("Location") != testcase.location || h.Get("Status") != "" {
+			t.Errorf("output %#v parsed as %d %v", testcase.output, status, h)
+		}
+		if rest, _ := r.ReadString(0); rest != testcase.body {
+			t.Errorf("output %#v left body %#v", testcase.output, rest)
+		}
+	}
+}
+
+func TestCGIEnv(t *testing.T) {
+	req := httptest.NewRequest("GET", "/cgi/x.sh?a=1", nil)
+	req.Header.Set("Proxy", "http://evil.example.com/")
+	req.Header.Set("X-Other", "kept")
+	handler := &WebsocketdHandler{
+		config:     &Config{},
+		Id:         "1",
+		RemoteInfo: &RemoteInfo{Addr: "127.0.0.1", Host: "localhost", Port: "1234"},
+		URLInfo:    &URLInfo{ScriptPath: "/cgi/x.sh", FilePath: "/srv/cgi/x.sh"},
+	}
+
+	var gateways []string
+	other := false
+	for _, kv := range createEnv(handler, req, cgiGatewayInterface, silentLog()) {
+		switch {
+		case strings.HasPrefix(kv, "GATEWAY_INTERFACE="):
+			gateways = append(gateways, kv)
+		case strings.HasPrefix(kv, "HTTP_PROXY="):
+			t.Errorf("Proxy header should not be passed, got %s", kv)
+		case kv == "HTTP_X_OTHER=kept":
+			other = true
+		}
+	}
+	if len(gateways) != 1 || gateways[0] != "GATEWAY_INTERFACE=CGI/1.1" {
+		t.Errorf("expected single GATEWAY_INTERFACE=CGI/1.1, got %q", gateways)
+	}
+	if !other {
+		t.Error("other headers should be passed")
+	}
+}
@@ -11,7 +11,7 @@ import (
 
 type Config struct {
 	// base initiaization fields
-	StartupTime    time.Time // Server startup time.
+	StartupTime    time.Time // Server startup time (used for dev console caching).
 	CommandName    string    // Command to execute.
 	CommandArgs    []string  // Additional args to pass to command.
 	ServerSoftware string    // Value to pass to SERVER_SOFTWARE environment variable (e.g. websocketd/1.2.3).
@@ -22,9 +22,41 @@ type Config struct {
 	// settings
 	Binary         bool     // Use binary communication (send data in chunks they are read from process)
 	ReverseLookup  bool     // Perform reverse DNS lookups on hostnames (useful, but slower).
+	Ssl            bool     // websocketd works with --ssl which means TLS is in use
+	ScriptDir      string   // Base directory for websocket scripts.
+	UsingScriptDir bool     // Are we running with a script dir.
+	StaticDir      string   // If set, static files will be served from this dir over HTTP.
+	StaticListing  bool     // Show listings of StaticDir directories that have no index.html.
+	CgiDir         string   // If set, CGI scripts will be served from this dir over HTTP.
+	DevConsole     bool     // Enable dev console. This disables StaticDir and CgiDir.
 	AllowOrigins   []string // List of allowed origin addresses for websocket upgrade.
 	SameOrigin     bool     // If set, requires websocket upgrades to be performed from same origin only.
-	Headers        []string
+	Headers        []string // Custom headers for every response ("Key: value").
+	HeadersWs      []string // Custom headers for successful WebSocket upgrade (101) responses only.
+	HeadersHTTP    []string // Custom headers for all but WebSocket upgrade responses.
+	Routes         []*Route // Commands for particular URL paths, checked before CommandName and ScriptDir.
+	QueryEnv       bool     // Export decoded query parameters as QUERY_<NAME> variables.
+	CookieEnv      bool     // Export cookies as COOKIE_<NAME> variables.
+	ParsedEnvLimit int      // Total size of QUERY_* and COOKIE_* variables in bytes, the rest are dropped.
+	MetricsPath    string   // If set, Prometheus metrics are served on this URL path.
+
+	// stderr of processes
+	StderrLevel     LogLevel // Level stderr lines are logged at, LogNone drops them.
+	StderrCategory  string   // Log category of stderr lines.
+	StderrLineLimit int      // Longer stderr lines are cut to this many bytes.
+	StderrForward   string   // Also send stderr lines to client: "binary" frames or "prefix"ed messages.
+	StderrPrefix    string   // Prefix of stderr lines sent to client with "prefix" forwarding.
+
+	// session transcripts
+	RecordDir   string // If set, transcript of every session is written to this directory.
+	RecordLimit int64  // Messages are not recorded once transcript grows past this many bytes (0 means no limit).
+
+	// health checks
+	HealthPath        string        // If set, liveness check is answered on this URL path.
+	ReadyPath         string        // If set, readiness check is answered on this URL path.
+	ReadyForksPercent int           // Not ready once this percentage of maxforks is in use (0 disables).
+	ReadyProbe        []string      // Command (resolved path and args) that has to succeed for server to be ready.
+	ReadyProbeTimeout time.Duration // Time ReadyProbe is given to finish.
 
 	// created environment
 	Env       []string // Additional environment variables to pass to process ("key=value").
@@ -0,0 +1,297 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+// Although this isn't particularly elegant, it's the simplest way to embed the
+// console content into the binary.
+//
+// Note that the console is served by websocketd itself when --devconsole is given,
+// {{addr}} and {{license}} placeholders are replaced before serving.
+
+const (
+	ConsoleContent = `
+<!DOCTYPE html>
+<meta charset="utf8">
+<title>websocketd console</title>
+
+<style>
+  html, body {
+    margin: 0;
+    height: 100%;
+  }
+  body {
+    display: flex;
+    flex-direction: column;
+    font-family: Menlo, Monaco, Consolas, 'Courier New', monospace;
+    font-size: 13px;
+    background: #1d1f21;
+    color: #c5c8c6;
+  }
+  .header {
+    display: flex;
+    padding: 6px;
+    background: #282a2e;
+    border-bottom: 1px solid #373b41;
+  }
+  .header input[type=text] {
+    flex: 1;
+    margin-right: 6px;
+  }
+  input, select, button {
+    font: inherit;
+    background: #1d1f21;
+    color: #c5c8c6;
+    border: 1px solid #373b41;
+    padding: 3px 6px;
+  }
+  button {
+    cursor: pointer;
+  }
+  .log {
+    flex: 1;
+    overflow-y: auto;
+    padding: 6px;
+    white-space: pre-wrap;
+    word-wrap: break-word;
+  }
+  .log .time {
+    color: #707880;
+  }
+  .log .send {
+    color: #81a2be;
+  }
+  .log .receive {
+    color: #b5bd68;
+  }
+  .log .error {
+    color: #cc6666;
+  }
+  .log .info {
+    color: #de935f;
+  }
+  .footer {
+    display: flex;
+    padding: 6px;
+    background: #282a2e;
+    border-top: 1px solid #373b41;
+  }
+  .footer input[type=text] {
+    flex: 1;
+    margin: 0 6px;
+  }
+  .license {
+    display: none;
+  }
+</style>
+
+<div class="header">
+  <input type="text" id="url" spellcheck="false">
+  <button id="connect">Connect</button>
+</div>
+<div class="log" id="log"></div>
+<div class="footer">
+  <select id="mode" title="How to send the message">
+    <option value="text">text</option>
+    <option value="binary">binary (utf8)</option>
+    <option value="hex">binary (hex)</option>
+  </select>
+  <input type="text" id="input" spellcheck="false" placeholder="Message to send, Up/Down to browse history" disabled>
+  <button id="send" disabled>Send</button>
+</div>
+<pre class="license">{{license}}</pre>
+
+<script>
+(function() {
+  var url = document.getElementById('url');
+  var connectButton = document.getElementById('connect');
+  var log = document.getElementById('log');
+  var mode = document.getElementById('mode');
+  var input = document.getElementById('input');
+  var sendButton = document.getElementById('send');
+
+  var ws = null;
+  var history = [];
+  var historyPos = 0;
+
+  url.value = '{{addr}}';
+
+  var closeCodes = {
+    1000: 'Normal Closure',
+    1001: 'Going Away',
+    1002: 'Protocol Error',
+    1003: 'Unsupported Data',
+    1005: 'No Status Received',
+    1006: 'Abnormal Closure',
+    1007: 'Invalid Frame Payload Data',
+    1008: 'Policy Violation',
+    1009: 'Message Too Big',
+    1010: 'Mandatory Extension',
+    1011: 'Internal Error',
+    1015: 'TLS Handshake'
+  };
+
+  function pad(n, width) {
+    n = String(n);
+    while (n.length < width) {
+      n = '0' + n;
+    }
+    return n;
+  }
+
+  function timestamp() {
+    var d = new Date();
+    return pad(d.getHours(), 2) + ':' + pad(d.getMinutes(), 2) + ':' +
+        pad(d.getSeconds(), 2) + '.' + pad(d.getMilliseconds(), 3);
+  }
+
+  function write(type, prefix, msg) {
+    var atBottom = log.scrollTop + log.clientHeight >= log.scrollHeight - 5;
+    var line = document.createElement('div');
+    var time = document.createElement('span');
+    time.className = 'time';
+    time.textContent = timestamp() + ' ';
+    var text = document.createElement('span');
+    text.className = type;
+    text.textContent = prefix + ' ' + msg;
+    line.appendChild(time);
+    line.appendChild(text);
+    log.appendChild(line);
+    if (atBottom) {
+      log.scrollTop = log.scrollHeight;
+    }
+  }
+
+  function toHex(buffer) {
+    var bytes = new Uint8Array(buffer);
+    var out = [];
+    for (var i = 0; i < bytes.length; i++) {
+      out.push(pad(bytes[i].toString(16), 2));
+    }
+    return out.join(' ');
+  }
+
+  function fromHex(s) {
+    var clean = s.replace(/[\s:,]|0x/g, '');
+    if (clean.length % 2 !== 0 || /[^0-9a-fA-F]/.test(clean)) {
+      throw new Error('not a valid hex string');
+    }
+    var bytes = new Uint8Array(clean.length / 2);
+    for (var i = 0; i < bytes.length; i++) {
+      bytes[i] = parseInt(clean.substr(i * 2, 2), 16);
+    }
+    return bytes;
+  }
+
+  function setConnected(connected) {
+    connectButton.textContent = connected ? 'Disconnect' : 'Connect';
+    url.disabled = connected;
+    input.disabled = !connected;
+    sendButton.disabled = !connected;
+    if (connected) {
+      input.focus();
+    }
+  }
+
+  function connect() {
+    write('info', '*', 'CONNECTING ' + url.value);
+    try {
+      ws = new WebSocket(url.value);
+    } catch (e) {
+      write('error', '!', e.message);
+      ws = null;
+      return;
+    }
+    ws.binaryType = 'arraybuffer';
+    ws.onopen = function() {
+      write('info', '*', 'CONNECTED');
+      setConnected(true);
+    };
+    ws.onmessage = function(ev) {
+      if (typeof ev.data === 'string') {
+        write('receive', '<', ev.data);
+      } else {
+        write('receive', '<', '[binary ' + ev.data.byteLength + ' bytes] ' + toHex(ev.data));
+      }
+    };
+    ws.onerror = function() {
+      write('error', '!', 'ERROR');
+    };
+    ws.onclose = function(ev) {
+      var reason = closeCodes[ev.code] || 'Unknown';
+      write('info', '*', 'DISCONNECTED code=' + ev.code + ' (' + reason + ')' +
+          (ev.reason ? ' reason=' + ev.reason : '') + (ev.wasClean ? '' : ' [not clean]'));
+      ws = null;
+      setConnected(false);
+    };
+  }
+
+  function send() {
+    if (!ws) {
+      return;
+    }
+    var msg = input.value;
+    try {
+      if (mode.value === 'text') {
+        ws.send(msg);
+        write('send', '>', msg);
+      } else {
+        var bytes = mode.value === 'hex' ? fromHex(msg) : new TextEncoder().encode(msg);
+        ws.send(bytes.buffer);
+        write('send', '>', '[binary ' + bytes.length + ' bytes] ' + toHex(bytes.buffer));
+      }
+    } catch (e) {
+      write('error', '!', e.message);
+      return;
+    }
+    if (msg !== '' && history[history.length - 1] !== msg) {
+      history.push(msg);
+    }
+    historyPos = history.length;
+    input.value = '';
+  }
+
+  connectButton.onclick = function() {
+    if (ws) {
+      ws.close(1000);
+    } else {
+      connect();
+    }
+  };
+
+  url.onkeydown = function(ev) {
+    if (ev.keyCode === 13 && !ws) {
+      connect();
+    }
+  };
+
+  sendButton.onclick = send;
+
+  input.onkeydown = function(ev) {
+    switch (ev.keyCode) {
+    case 13: // enter
+      send();
+      break;
+    case 38: // up
+      if (historyPos > 0) {
+        input.value = history[--historyPos];
+      }
+      ev.preventDefault();
+      break;
+    case 40: // down
+      if (historyPos < history.length) {
+        historyPos++;
+        input.value = historyPos < history.length ? history[historyPos] : '';
+      }
+      ev.preventDefault();
+      break;
+    }
+  };
+
+  write('info', '*', 'websocketd developer console. Press Connect to start.');
+})();
+</script>
+`
+)
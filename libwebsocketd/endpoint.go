@@ -13,6 +13,13 @@ type Endpoint interface {
 }
 
 func PipeEndpoints(e1, e2 Endpoint) {
+	pipeEndpoints(e1, e2, nil)
+}
+
+// pipeEndpoints calls count for every message passed, fromFirst tells if it
+// came from e1. It returns true if piping stopped because of e1: its output
+// was closed or it did not accept a message.
+func pipeEndpoints(e1, e2 Endpoint, count func(fromFirst bool, msg []byte)) bool {
 	e1.StartReading()
 	e2.StartReading()
 
@@ -21,12 +28,24 @@ func PipeEndpoints(e1, e2 Endpoint) {
 	for {
 		select {
 		case msgOne, ok := <-e1.Output():
-			if !ok || !e2.Send(msgOne) {
-				return
+			if !ok {
+				return true
+			}
+			if !e2.Send(msgOne) {
+				return false
+			}
+			if count != nil {
+				count(true, msgOne)
 			}
 		case msgTwo, ok := <-e2.Output():
-			if !ok || !e1.Send(msgTwo) {
-				return
+			if !ok {
+				return false
+			}
+			if !e1.Send(msgTwo) {
+				return true
+			}
+			if count != nil {
+				count(false, msgTwo)
 			}
 		}
 	}
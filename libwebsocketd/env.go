@@ -6,8 +6,15 @@
 package libwebsocketd
 
 import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"net/http"
+	neturl "net/url"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -18,28 +25,30 @@ const (
 var headerNewlineToSpace = strings.NewReplacer("\n", " ", "\r", " ")
 var headerDashToUnderscore = strings.NewReplacer("-", "_")
 
-func createEnv(handler *WebsocketdHandler, req *http.Request, log *LogScope) []string {
+// createEnv builds environment of the process handling req, gateway is the
+// value of GATEWAY_INTERFACE.
+func createEnv(handler *WebsocketdHandler, req *http.Request, gateway string, log *LogScope) []string {
 	headers := req.Header
 
 	url := req.URL
 
-	serverName, serverPort, err := tellHostPort(req.Host)
+	serverName, serverPort, err := tellHostPort(req.Host, handler.config.Ssl)
 	if err != nil {
 		// This does mean that we cannot detect port from Host: header... Just keep going with "", guessing is bad.
 		log.Debug("env", "Host port detection error: %s", err)
 		serverPort = ""
 	}
 
-	standardEnvCount := 20
+	standardEnvCount := 23
 
-	parentLen := len(handler.server.Config.ParentEnv)
-	env := make([]string, 0, len(headers)+standardEnvCount+parentLen+len(handler.server.Config.Env))
+	parentLen := len(handler.config.ParentEnv)
+	env := make([]string, 0, len(headers)+standardEnvCount+parentLen+len(handler.URLInfo.PathParams)+len(handler.config.Env))
 
 	// This variable could be rewritten from outside
-	env = appendEnv(env, "SERVER_SOFTWARE", handler.server.Config.ServerSoftware)
+	env = appendEnv(env, "SERVER_SOFTWARE", handler.config.ServerSoftware)
 
 	parentStarts := len(env)
-	env = append(env, handler.server.Config.ParentEnv...)
+	env = append(env, handler.config.ParentEnv...)
 
 	// IMPORTANT ---> Adding a header? Make sure standardEnvCount (above) is up to date.
 
@@ -50,16 +59,23 @@ func createEnv(handler *WebsocketdHandler, req *http.Request, log *LogScope) []s
 	env = appendEnv(env, "SERVER_NAME", serverName)
 	env = appendEnv(env, "SERVER_PORT", serverPort)
 	env = appendEnv(env, "SERVER_PROTOCOL", req.Proto)
-	env = appendEnv(env, "GATEWAY_INTERFACE", gatewayInterface)
+	env = appendEnv(env, "GATEWAY_INTERFACE", gateway)
 	env = appendEnv(env, "REQUEST_METHOD", req.Method)
 	env = appendEnv(env, "PATH_INFO", handler.URLInfo.PathInfo)
 	env = appendEnv(env, "PATH_TRANSLATED", url.Path)
 	env = appendEnv(env, "QUERY_STRING", url.RawQuery)
+	env = appendEnv(env, "SCRIPT_NAME", handler.URLInfo.ScriptPath)
+
+	// Only CGI requests have body, for WebSockets these are empty.
+	contentLength := ""
+	if req.ContentLength > 0 {
+		contentLength = strconv.FormatInt(req.ContentLength, 10)
+	}
+	env = appendEnv(env, "CONTENT_LENGTH", contentLength)
+	env = appendEnv(env, "CONTENT_TYPE", headers.Get("Content-Type"))
 
 	// Not supported, but we explicitly clear them so we don't get leaks from parent environment.
 	env = appendEnv(env, "AUTH_TYPE", "")
-	env = appendEnv(env, "CONTENT_LENGTH", "")
-	env = appendEnv(env, "CONTENT_TYPE", "")
 	env = appendEnv(env, "REMOTE_IDENT", "")
 	env = appendEnv(env, "REMOTE_USER", "")
 
@@ -67,15 +83,42 @@ func createEnv(handler *WebsocketdHandler, req *http.Request, log *LogScope) []s
 	env = appendEnv(env, "UNIQUE_ID", handler.Id) // Based on Apache mod_unique_id.
 	env = appendEnv(env, "REMOTE_PORT", handler.RemoteInfo.Port)
 	env = appendEnv(env, "REQUEST_URI", url.RequestURI()) // e.g. /foo/blah?a=b
+	env = appendEnv(env, "SCRIPT_FILENAME", handler.URLInfo.FilePath)
+
+	for _, p := range handler.URLInfo.PathParams {
+		env = appendEnv(env, "PATH_PARAM_"+strings.ToUpper(p.Name), p.Value)
+	}
+
+	if handler.config.QueryEnv || handler.config.CookieEnv {
+		budget := &parsedEnvBudget{handler.config.ParsedEnvLimit, handler.config.ParsedEnvLimit}
+		if handler.config.QueryEnv {
+			query, err := neturl.ParseQuery(url.RawQuery)
+			if err != nil {
+				log.Debug("env", "Query string is not entirely parseable: %s", err)
+			}
+			env = appendParsedEnv(env, "QUERY_", query, budget, log)
+		}
+		if handler.config.CookieEnv {
+			cookies := make(map[string][]string)
+			for _, c := range req.Cookies() {
+				cookies[c.Name] = append(cookies[c.Name], c.Value)
+			}
+			env = appendParsedEnv(env, "COOKIE_", cookies, budget, log)
+		}
+	}
+
+	if handler.config.Ssl {
+		env = appendEnv(env, "HTTPS", "on")
+	}
+	if req.TLS != nil {
+		env = appendTLSEnv(env, req.TLS)
+	}
 
 	// The following variables are part of the CGI specification, but are optional
 	// and not set by websocketd:
 	//
 	//   AUTH_TYPE, REMOTE_USER, REMOTE_IDENT
 	//     -- Authentication left to the underlying programs.
-	//
-	//   CONTENT_LENGTH, CONTENT_TYPE
-	//     -- makes no sense for WebSocket connections.
 
 	if log.MinLevel == LogDebug {
 		for i, v := range env {
@@ -88,12 +131,21 @@ func createEnv(handler *WebsocketdHandler, req *http.Request, log *LogScope) []s
 	}
 
 	for k, hdrs := range headers {
+		if k == "Proxy" {
+			// HTTP_PROXY would be taken as proxy setting by many programs,
+			// see https://httpoxy.org
+			log.Debug("env", "Header Proxy is not passed")
+			continue
+		}
 		header := fmt.Sprintf("HTTP_%s", headerDashToUnderscore.Replace(k))
 		env = appendEnv(env, header, hdrs...)
 		log.Debug("env", "Header variable %s", env[len(env)-1])
 	}
 
-	for _, v := range handler.server.Config.Env {
+	for _, v := range handler.config.Env {
+		if p := strings.IndexByte(v, '='); p > 0 {
+			v = v[:p+1] + cleanEnvValue(v[p+1:])
+		}
 		env = append(env, v)
 		log.Debug("env", "External variable: %s", v)
 	}
@@ -101,6 +153,53 @@ func createEnv(handler *WebsocketdHandler, req *http.Request, log *LogScope) []s
 	return env
 }
 
+var tlsVersionNames = map[uint16]string{
+	tls.VersionTLS10: "TLSv1",
+	tls.VersionTLS11: "TLSv1.1",
+	tls.VersionTLS12: "TLSv1.2",
+	tls.VersionTLS13: "TLSv1.3",
+}
+
+// appendTLSEnv adds connection and client certificate details using
+// variable names of Apache mod_ssl (SSL_CLIENT_S_DN, SSL_CLIENT_VERIFY, ...).
+func appendTLSEnv(env []string, state *tls.ConnectionState) []string {
+	env = appendEnv(env, "SSL_PROTOCOL", tlsVersionNames[state.Version])
+	env = appendEnv(env, "SSL_CIPHER", tls.CipherSuiteName(state.CipherSuite))
+
+	// client certificates are requested only to be verified against --sslclientca,
+	// so presented one either has verified chain or handshake has failed
+	if len(state.PeerCertificates) == 0 || len(state.VerifiedChains) == 0 {
+		return appendEnv(env, "SSL_CLIENT_VERIFY", "NONE")
+	}
+	env = appendEnv(env, "SSL_CLIENT_VERIFY", "SUCCESS")
+
+	cert := state.PeerCertificates[0]
+	fingerprint := sha256.Sum256(cert.Raw)
+
+	env = appendEnv(env, "SSL_CLIENT_S_DN", cert.Subject.String())
+	env = appendEnv(env, "SSL_CLIENT_S_DN_CN", cert.Subject.CommonName)
+	env = appendEnv(env, "SSL_CLIENT_I_DN", cert.Issuer.String())
+	env = appendEnv(env, "SSL_CLIENT_I_DN_CN", cert.Issuer.CommonName)
+	env = appendEnv(env, "SSL_CLIENT_M_SERIAL", strings.ToUpper(cert.SerialNumber.Text(16)))
+	env = appendEnv(env, "SSL_CLIENT_V_START", cert.NotBefore.UTC().Format("Jan _2 15:04:05 2006 GMT"))
+	env = appendEnv(env, "SSL_CLIENT_V_END", cert.NotAfter.UTC().Format("Jan _2 15:04:05 2006 GMT"))
+	env = appendEnv(env, "SSL_CLIENT_FINGERPRINT", strings.ToUpper(hex.EncodeToString(fingerprint[:])))
+
+	for i, name := range cert.DNSNames {
+		env = appendEnv(env, "SSL_CLIENT_SAN_DNS_"+strconv.Itoa(i), name)
+	}
+	for i, email := range cert.EmailAddresses {
+		env = appendEnv(env, "SSL_CLIENT_SAN_EMAIL_"+strconv.Itoa(i), email)
+	}
+	for i, ip := range cert.IPAddresses {
+		env = appendEnv(env, "SSL_CLIENT_SAN_IP_"+strconv.Itoa(i), ip.String())
+	}
+	for i, uri := range cert.URIs {
+		env = appendEnv(env, "SSL_CLIENT_SAN_URI_"+strconv.Itoa(i), uri.String())
+	}
+	return env
+}
+
 // Adapted from net/http/header.go
 func appendEnv(env []string, k string, v ...string) []string {
 	if len(v) == 0 {
@@ -109,9 +208,100 @@ func appendEnv(env []string, k string, v ...string) []string {
 
 	vCleaned := make([]string, 0, len(v))
 	for _, val := range v {
-		vCleaned = append(vCleaned, strings.TrimSpace(headerNewlineToSpace.Replace(val)))
+		vCleaned = append(vCleaned, cleanEnvValue(val))
 	}
 	return append(env, fmt.Sprintf("%s=%s",
 		strings.ToUpper(k),
 		strings.Join(vCleaned, ", ")))
 }
+
+// cleanEnvValue makes value safe to be passed in environment: newlines become
+// spaces and surrounding whitespace is removed.
+func cleanEnvValue(v string) string {
+	return strings.TrimSpace(headerNewlineToSpace.Replace(v))
+}
+
+var envNameCleaner = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// parsedEnvBudget is space left for QUERY_* and COOKIE_* variables, left
+// becomes negative once something was dropped.
+type parsedEnvBudget struct {
+	limit, left int
+}
+
+// appendParsedEnv adds values as prefix+NAME variables. Names are uppercased and
+// everything but letters, digits and underscore becomes underscore. Repeated names
+// also get NAME_COUNT and NAME_1 ... NAME_N variables with every value. Names that
+// would replace variables already in env (e.g. QUERY_STRING) or ones made for
+// repeated names are dropped. Variables are added while they fit into budget,
+// the rest are dropped.
+func appendParsedEnv(env []string, prefix string, values map[string][]string, budget *parsedEnvBudget, log *LogScope) []string {
+	if budget.left < 0 {
+		return env // limit was hit already
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	names := make([]string, 0, len(values))
+	merged := make(map[string][]string, len(values))
+	for _, k := range keys {
+		name := envNameCleaner.ReplaceAllString(strings.ToUpper(k), "_")
+		if name == "" || len(values[k]) == 0 {
+			continue
+		}
+		if _, seen := merged[name]; !seen {
+			names = append(names, name)
+		}
+		merged[name] = append(merged[name], values[k]...)
+	}
+	sort.Strings(names)
+
+	// every variable name is owned by single value, client must not be able to
+	// replace standard variables or the ones describing repeated values
+	taken := make(map[string]bool, len(env))
+	for _, kv := range env {
+		if p := strings.IndexByte(kv, '='); p > 0 {
+			taken[kv[:p]] = true
+		}
+	}
+	vars := make(map[string][][2]string, len(names))
+	for _, name := range names {
+		vals := merged[name]
+		vars[name] = [][2]string{{prefix + name, vals[0]}}
+		if len(vals) > 1 {
+			vars[name] = append(vars[name], [2]string{prefix + name + "_COUNT", strconv.Itoa(len(vals))})
+			for i, v := range vals {
+				vars[name] = append(vars[name], [2]string{fmt.Sprintf("%s%s_%d", prefix, name, i+1), v})
+			}
+			for _, kv := range vars[name][1:] {
+				taken[kv[0]] = true
+			}
+		}
+	}
+
+	for _, name := range names {
+		if taken[prefix+name] {
+			log.Access("env", "Variable %s%s would clash with another variable, it is dropped", prefix, name)
+			continue
+		}
+
+		size := 0
+		for _, kv := range vars[name] {
+			size += len(kv[0]) + len(kv[1]) + 2 // "=" and terminating zero
+		}
+		if size > budget.left {
+			log.Access("env", "Variables exceed limit of %d bytes, %s%s and the rest are dropped", budget.limit, prefix, name)
+			budget.left = -1
+			return env
+		}
+		budget.left -= size
+		for _, kv := range vars[name] {
+			env = appendEnv(env, kv[0], kv[1])
+		}
+	}
+	return env
+}
@@ -0,0 +1,170 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envLookup(env []string, key string) (string, bool) {
+	for _, v := range env {
+		if strings.HasPrefix(v, key+"=") {
+			return v[len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+func TestAppendTLSEnv(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:   big.NewInt(0x1f2e),
+		Subject:        pkix.Name{CommonName: "alice", Organization: []string{"Example"}},
+		Issuer:         pkix.Name{CommonName: "alice"},
+		NotBefore:      time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC),
+		NotAfter:       time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+		DNSNames:       []string{"alice.example.com"},
+		EmailAddresses: []string{"alice@example.com"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := appendTLSEnv(nil, &tls.ConnectionState{Version: tls.VersionTLS12})
+	if v, _ := envLookup(env, "SSL_CLIENT_VERIFY"); v != "NONE" {
+		t.Errorf("SSL_CLIENT_VERIFY should be NONE without certificate, got %#v", v)
+	}
+	if _, ok := envLookup(env, "SSL_CLIENT_S_DN"); ok {
+		t.Error("SSL_CLIENT_S_DN should not be set without certificate")
+	}
+
+	env = appendTLSEnv(nil, &tls.ConnectionState{
+		Version:          tls.VersionTLS12,
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	})
+	expected := map[string]string{
+		"SSL_PROTOCOL":           "TLSv1.2",
+		"SSL_CLIENT_VERIFY":      "SUCCESS",
+		"SSL_CLIENT_S_DN":        "CN=alice,O=Example",
+		"SSL_CLIENT_S_DN_CN":     "alice",
+		"SSL_CLIENT_M_SERIAL":    "1F2E",
+		"SSL_CLIENT_V_START":     "Jan  2 03:04:05 2020 GMT",
+		"SSL_CLIENT_SAN_DNS_0":   "alice.example.com",
+		"SSL_CLIENT_SAN_EMAIL_0": "alice@example.com",
+	}
+	for k, v := range expected {
+		if got, _ := envLookup(env, k); got != v {
+			t.Errorf("%s should be %#v, got %#v", k, v, got)
+		}
+	}
+	if v, _ := envLookup(env, "SSL_CLIENT_FINGERPRINT"); len(v) != 64 {
+		t.Errorf("SSL_CLIENT_FINGERPRINT should be hex encoded sha256, got %#v", v)
+	}
+}
+
+func TestAppendParsedEnv(t *testing.T) {
+	log, logged := capturedLog(LogAccess, "env")
+	values := map[string][]string{
+		"name":  {"joe walnes"},
+		"x-y":   {"1"},
+		"X_Y":   {"2"},
+		"tag":   {"a", "b\nc"},
+		"!!!":   {"weird"},
+		"empty": {},
+		"":      {"nothing"},
+	}
+	budget := &parsedEnvBudget{1000, 1000}
+	env := appendParsedEnv(nil, "QUERY_", values, budget, log)
+
+	expected := map[string]string{
+		"QUERY_NAME":      "joe walnes",
+		"QUERY____":       "weird",
+		"QUERY_TAG":       "a",
+		"QUERY_TAG_COUNT": "2",
+		"QUERY_TAG_1":     "a",
+		"QUERY_TAG_2":     "b c",
+		"QUERY_X_Y":       "2",
+		"QUERY_X_Y_COUNT": "2",
+		"QUERY_X_Y_1":     "2",
+		"QUERY_X_Y_2":     "1",
+	}
+	if len(env) != len(expected) {
+		t.Errorf("expected %d variables, got %v", len(expected), env)
+	}
+	for k, v := range expected {
+		if got, ok := envLookup(env, k); !ok || got != v {
+			t.Errorf("%s: expected %q, got %q (%v)", k, v, got, ok)
+		}
+	}
+
+	// names are added in sorted order, QUERY_TAG does not fit anymore
+	limit := len("QUERY_NAME=joe walnes") + 1 + 10
+	budget = &parsedEnvBudget{limit, limit}
+	env = appendParsedEnv(nil, "QUERY_", values, budget, log)
+	if len(env) != 1 || budget.left != -1 {
+		t.Errorf("expected only one variable to fit, got %v", env)
+	}
+	if env = appendParsedEnv(env, "COOKIE_", map[string][]string{"a": {"b"}}, budget, log); len(env) != 1 {
+		t.Errorf("nothing should be added once limit was hit, got %v", env)
+	}
+	if msg := fmt.Sprintf("ACCESS Variables exceed limit of %d bytes, QUERY_TAG and the rest are dropped", limit); len(*logged) == 0 || (*logged)[len(*logged)-1] != msg {
+		t.Errorf("expected %q to be logged, got %q", msg, *logged)
+	}
+}
+
+func TestAppendParsedEnvClashes(t *testing.T) {
+	env := []string{"QUERY_STRING=a=1&string=evil", "SERVER_SOFTWARE=websocketd"}
+	values := map[string][]string{
+		"string":  {"evil"},
+		"x":       {"1", "2"},
+		"x_count": {"99"},
+		"x_2":     {"evil"},
+		"x_3":     {"fine"},
+	}
+	env = appendParsedEnv(env, "QUERY_", values, &parsedEnvBudget{1000, 1000}, silentLog())
+
+	expected := map[string]string{
+		"QUERY_STRING":  "a=1&string=evil",
+		"QUERY_X":       "1",
+		"QUERY_X_COUNT": "2",
+		"QUERY_X_1":     "1",
+		"QUERY_X_2":     "2",
+		"QUERY_X_3":     "fine",
+	}
+	if len(env) != len(expected)+1 {
+		t.Errorf("expected %d variables, got %v", len(expected)+1, env)
+	}
+	for k, v := range expected {
+		got := ""
+		for _, kv := range env {
+			if strings.HasPrefix(kv, k+"=") {
+				got = kv[len(k)+1:] // last one wins in os/exec
+			}
+		}
+		if got != v {
+			t.Errorf("%s: expected %q, got %q", k, v, got)
+		}
+	}
+}
@@ -1,18 +1,27 @@
 package libwebsocketd
 
 import (
+	"errors"
+	"fmt"
 	"net"
 	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+var ScriptNotFoundError = errors.New("script not found")
+
 // WebsocketdHandler is a single request information and processing structure, it handles WS requests out of all that daemon can handle
 type WebsocketdHandler struct {
 	server *WebsocketdServer
+	config *Config // configuration snapshot taken when request came in
 
 	Id string
 	*RemoteInfo
@@ -20,30 +29,55 @@ type WebsocketdHandler struct {
 	Env      []string
 
 	command string
+	url     string // WebSocket URL as client requested it
 }
 
 // NewWebsocketdHandler constructs the struct and parses all required things in it...
 func NewWebsocketdHandler(s *WebsocketdServer, req *http.Request, log *LogScope) (wsh *WebsocketdHandler, err error) {
-	wsh = &WebsocketdHandler{server: s, Id: generateId()}
+	config := s.currentConfig()
+	route, urlInfo, err := matchRoute(config.Routes, req.URL.Path)
+	if err != nil {
+		return nil, ScriptNotFoundError
+	}
+	if route != nil {
+		config = route.apply(config)
+	}
+	return newWebsocketdHandler(s, config, urlInfo, req, log)
+}
+
+// newWebsocketdHandler works with config of the route (if any) that matched the
+// request, urlInfo is figured out from config when route did not provide one.
+func newWebsocketdHandler(s *WebsocketdServer, config *Config, urlInfo *URLInfo, req *http.Request, log *LogScope) (wsh *WebsocketdHandler, err error) {
+	wsh = &WebsocketdHandler{server: s, config: config, Id: generateId(), url: tellURL(config, "ws", req.Host, req.RequestURI)}
 	log.Associate("id", wsh.Id)
 
-	wsh.RemoteInfo, err = GetRemoteInfo(req.RemoteAddr, s.Config.ReverseLookup)
+	wsh.RemoteInfo, err = GetRemoteInfo(req.RemoteAddr, config.ReverseLookup)
 	if err != nil {
 		log.Error("session", "Could not understand remote address '%s': %s", req.RemoteAddr, err)
 		return nil, err
 	}
 	log.Associate("remote", wsh.RemoteInfo.Host)
 
-	wsh.URLInfo, err = GetURLInfo(req.URL.Path, s.Config)
-	if err != nil {
-		log.Access("session", "NOT FOUND: %s", err)
-		return nil, err
+	wsh.URLInfo = urlInfo
+	if wsh.URLInfo == nil {
+		wsh.URLInfo, err = GetURLInfo(req.URL.Path, config)
+		if err != nil {
+			log.Access("session", "NOT FOUND: %s", err)
+			return nil, err
+		}
 	}
 
-	wsh.command = s.Config.CommandName
+	wsh.command = config.CommandName
+	if config.UsingScriptDir {
+		if !isExecutable(wsh.URLInfo.FilePath) {
+			log.Debug("session", "Script %s is not executable", wsh.URLInfo.FilePath)
+			return nil, ScriptNotFoundError
+		}
+		wsh.command = wsh.URLInfo.FilePath
+	}
 	log.Associate("command", wsh.command)
 
-	wsh.Env = createEnv(wsh, req, log)
+	wsh.Env = createEnv(wsh, req, gatewayInterface, log)
 
 	return wsh, nil
 }
@@ -54,22 +88,66 @@ func (wsh *WebsocketdHandler) accept(ws *websocket.Conn, log *LogScope) {
 	log.Access("session", "CONNECT")
 	defer log.Access("session", "DISCONNECT")
 
-	launched, err := launchCmd(wsh.command, wsh.server.Config.CommandArgs, wsh.Env)
+	metrics := &wsh.server.metrics
+	started := time.Now()
+	defer func() { metrics.sessionFinished(time.Since(started)) }()
+
+	launched, err := launchCmd(wsh.command, wsh.config.CommandArgs, wsh.Env)
 	if err != nil {
-		log.Error("process", "Could not launch process %s %s (%s)", wsh.command, strings.Join(wsh.server.Config.CommandArgs, " "), err)
+		log.Error("process", "Could not launch process %s %s (%s)", wsh.command, strings.Join(wsh.config.CommandArgs, " "), err)
+		metrics.spawnFailed()
 		return
 	}
 
 	log.Associate("pid", strconv.Itoa(launched.cmd.Process.Pid))
 
-	binary := wsh.server.Config.Binary
+	binary := wsh.config.Binary
 	process := NewProcessEndpoint(launched, binary, log)
-	if cms := wsh.server.Config.CloseMs; cms != 0 {
+	if cms := wsh.config.CloseMs; cms != 0 {
 		process.closetime += time.Duration(cms) * time.Millisecond
 	}
 	wsEndpoint := NewWebSocketEndpoint(ws, binary, log)
+	process.stderr = newStderrHandling(wsh.config)
+	if mode := wsh.config.StderrForward; mode != "" {
+		prefix := wsh.config.StderrPrefix
+		process.stderr.forward = func(line []byte) { wsEndpoint.sendStderr(line, mode, prefix) }
+	}
 
-	PipeEndpoints(process, wsEndpoint)
+	s := &session{handler: wsh, ws: ws, process: process, log: log, started: started}
+	if err := wsh.server.addSession(s); err != nil {
+		log.Access("session", "Closing session: %s", err)
+		ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(closeFrameTimeout))
+		process.Terminate()
+		metrics.processExited(process.stage, process.exitCode)
+		return
+	}
+	defer wsh.server.removeSession(s)
+
+	var rec *recorder
+	if wsh.config.RecordDir != "" {
+		if rec, err = newRecorder(wsh, launched.cmd.Process.Pid, started); err != nil {
+			log.Error("session", "Could not record session: %s", err)
+		}
+	}
+
+	processEnded := pipeEndpoints(process, wsEndpoint, func(fromProcess bool, msg []byte) {
+		direction := directionIn
+		if fromProcess {
+			direction = directionOut
+			atomic.AddInt64(&s.bytesOut, int64(len(msg)))
+		} else {
+			atomic.AddInt64(&s.bytesIn, int64(len(msg)))
+		}
+		metrics.message(direction, len(msg))
+		if rec != nil {
+			rec.message(direction, msg)
+		}
+	})
+	metrics.processExited(process.stage, process.exitCode)
+	if rec != nil {
+		closedBy, code, reason := closeDetails(s, wsEndpoint, processEnded)
+		rec.finish(closedBy, code, reason, process)
+	}
 }
 
 // RemoteInfo holds information about remote http client
@@ -79,6 +157,11 @@ type RemoteInfo struct {
 
 // GetRemoteInfo creates RemoteInfo structure and fills its fields appropriately
 func GetRemoteInfo(remote string, doLookup bool) (*RemoteInfo, error) {
+	if remote == "" || remote == "@" {
+		// unix domain socket clients have no address
+		return &RemoteInfo{}, nil
+	}
+
 	addr, port, err := net.SplitHostPort(remote)
 	if err != nil {
 		return nil, err
@@ -101,15 +184,99 @@ func GetRemoteInfo(remote string, doLookup bool) (*RemoteInfo, error) {
 
 // URLInfo - structure carrying information about current request and it's mapping to filesystem
 type URLInfo struct {
+	ScriptPath string
 	PathInfo   string
 	FilePath   string
+	PathParams []PathParam // values captured by {param} segments of Route.Path
+}
+
+// PathParam is a piece of url path captured by route pattern
+type PathParam struct {
+	Name, Value string
 }
 
 // GetURLInfo is a function that parses path and provides URL info according to libwebsocketd.Config fields
 func GetURLInfo(path string, config *Config) (*URLInfo, error) {
-	return &URLInfo{path, ""}, nil
+	if !config.UsingScriptDir {
+		return &URLInfo{ScriptPath: "/", PathInfo: path}, nil
+	}
+	return findScript(path, config.ScriptDir)
+}
+
+// findScript walks url path segments until it finds file inside of dir, the rest
+// of the path becomes PATH_INFO.
+func findScript(path string, dir string) (*URLInfo, error) {
+	if len(path) == 0 || path[0] != '/' {
+		return nil, ScriptNotFoundError
+	}
+
+	parts := strings.Split(path[1:], "/")
+	urlInfo := &URLInfo{}
+
+	for i, part := range parts {
+		// never let the url to climb out of script dir
+		if part == ".." || strings.ContainsRune(part, filepath.Separator) {
+			return nil, ScriptNotFoundError
+		}
+
+		urlInfo.ScriptPath = strings.Join([]string{urlInfo.ScriptPath, part}, "/")
+		urlInfo.FilePath = filepath.Join(dir, urlInfo.ScriptPath)
+		isLastPart := i == len(parts)-1
+		statInfo, err := os.Stat(urlInfo.FilePath)
+
+		// not a valid path
+		if err != nil {
+			return nil, ScriptNotFoundError
+		}
+
+		// at the end of url but is a dir
+		if isLastPart && statInfo.IsDir() {
+			return nil, ScriptNotFoundError
+		}
+
+		// we've hit a dir, carry on looking
+		if statInfo.IsDir() {
+			continue
+		}
+
+		// no extra args
+		if isLastPart {
+			return urlInfo, nil
+		}
+
+		// build path info from extra parts of url
+		urlInfo.PathInfo = "/" + strings.Join(parts[i+1:], "/")
+		return urlInfo, nil
+	}
+	panic(fmt.Sprintf("findScript cannot parse path %#v", path))
 }
 
+// isExecutable tells if file could be launched as a script. Windows has no
+// executable bits so any regular file is accepted there.
+func isExecutable(path string) bool {
+	fi, err := os.Stat(path)
+	if err != nil || fi.IsDir() {
+		return false
+	}
+	if runtime.GOOS == "windows" {
+		return true
+	}
+	return fi.Mode()&0111 != 0
+}
+
+// lastId is the last value returned by generateId
+var lastId int64
+
+// generateId returns time in nanoseconds, bumped if needed so that every call
+// gets a unique id even when clock is coarse or goes back.
 func generateId() string {
-	return strconv.FormatInt(time.Now().UnixNano(), 10)
+	for {
+		last, id := atomic.LoadInt64(&lastId), time.Now().UnixNano()
+		if id <= last {
+			id = last + 1
+		}
+		if atomic.CompareAndSwapInt64(&lastId, last, id) {
+			return strconv.FormatInt(id, 10)
+		}
+	}
 }
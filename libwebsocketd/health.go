@@ -0,0 +1,113 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// probeCacheTime is how long result of ReadyProbe is reused, so frequent checks
+// by several load balancers do not spawn a process each.
+const probeCacheTime = time.Second
+
+// probeState is the last result of ReadyProbe command
+type probeState struct {
+	mu      sync.Mutex
+	checked time.Time
+	err     error
+}
+
+// serveHealth answers HealthPath: server is up as long as it answers.
+func (h *WebsocketdServer) serveHealth(w http.ResponseWriter, log *LogScope) {
+	log.Debug("http", "HEALTH")
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	io.WriteString(w, "ok\n")
+}
+
+// serveReady answers ReadyPath with 503 when server should not get new
+// connections: it's shutting down, maxforks is nearly used up or ReadyProbe fails.
+func (h *WebsocketdServer) serveReady(w http.ResponseWriter, config *Config, log *LogScope) {
+	err := h.checkReady(config)
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if err != nil {
+		log.Debug("http", "NOT READY: %s", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintf(w, "not ready: %s\n", err)
+		return
+	}
+	log.Debug("http", "READY")
+	io.WriteString(w, "ok\n")
+}
+
+func (h *WebsocketdServer) checkReady(config *Config) error {
+	if h.isDraining() {
+		return errors.New("shutting down")
+	}
+
+	h.forksMu.Lock()
+	forks, maxForks := h.forks, h.maxForks
+	h.forksMu.Unlock()
+	if maxForks > 0 && config.ReadyForksPercent > 0 && forks*100 >= maxForks*config.ReadyForksPercent {
+		return fmt.Errorf("%d of %d forks are in use", forks, maxForks)
+	}
+
+	if len(config.ReadyProbe) > 0 {
+		return h.probe.check(config)
+	}
+	return nil
+}
+
+// check runs ReadyProbe unless it was run recently.
+func (p *probeState) check(config *Config) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if time.Since(p.checked) < probeCacheTime {
+		return p.err
+	}
+	p.err = runProbe(config)
+	p.checked = time.Now()
+	return p.err
+}
+
+// runProbe launches ReadyProbe with websocketd environment and waits for it for
+// ReadyProbeTimeout at most, non-zero exit code means server is not ready.
+func runProbe(config *Config) error {
+	args := config.ReadyProbe
+	env := append(append(make([]string, 0, len(config.ParentEnv)+len(config.Env)), config.ParentEnv...), config.Env...)
+	launched, err := launchCmd(args[0], args[1:], env)
+	if err != nil {
+		return fmt.Errorf("probe could not be started: %s", err)
+	}
+	launched.stdin.Close()
+	go io.Copy(ioutil.Discard, launched.stdout)
+	go io.Copy(ioutil.Discard, launched.stderr)
+
+	done := make(chan error, 1)
+	go func() { done <- launched.cmd.Wait() }()
+
+	timeout := config.ReadyProbeTimeout
+	if timeout <= 0 {
+		timeout = probeCacheTime
+	}
+	select {
+	case err = <-done:
+	case <-time.After(timeout):
+		launched.cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("probe did not finish in %s", timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("probe failed: %s", err)
+	}
+	return nil
+}
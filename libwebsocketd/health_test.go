@@ -0,0 +1,66 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestCheckReady(t *testing.T) {
+	config := &Config{ReadyForksPercent: 75}
+	h := NewWebsocketdServer(config, nil, 4)
+	for i := 0; i < 2; i++ {
+		h.noteForkCreated(nil)
+	}
+	if err := h.checkReady(config); err != nil {
+		t.Errorf("server with 2 of 4 forks should be ready: %s", err)
+	}
+	h.noteForkCreated(nil)
+	if err := h.checkReady(config); err == nil {
+		t.Error("server with 3 of 4 forks should not be ready")
+	}
+	if err := h.checkReady(&Config{}); err != nil {
+		t.Errorf("forks should not matter without ReadyForksPercent: %s", err)
+	}
+
+	h.StopAccepting()
+	if err := h.checkReady(&Config{}); err == nil {
+		t.Error("draining server should not be ready")
+	}
+}
+
+// probe resolves command the way parseConfig does for ReadyProbe.
+func probe(t *testing.T, name string, args ...string) []string {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		t.Skip(err)
+	}
+	return append([]string{path}, args...)
+}
+
+func TestReadyProbe(t *testing.T) {
+	if err := runProbe(&Config{ReadyProbe: probe(t, "true")}); err != nil {
+		t.Errorf("probe with 'true' failed: %s", err)
+	}
+	if err := runProbe(&Config{ReadyProbe: probe(t, "false")}); err == nil {
+		t.Error("probe with 'false' passed")
+	}
+	if err := runProbe(&Config{ReadyProbe: probe(t, "sleep", "5"), ReadyProbeTimeout: 50 * time.Millisecond}); err == nil {
+		t.Error("probe that is too slow passed")
+	}
+}
+
+func TestHealthHeaders(t *testing.T) {
+	config := &Config{HealthPath: "/healthz", Headers: []string{"X-Any: 1"}, HeadersHTTP: []string{"X-Http: 2"}}
+	h := NewWebsocketdServer(config, silentLog(), 0)
+
+	rec := serve(h, "GET", "/healthz", nil)
+	if rec.Code != 200 || rec.Header().Get("X-Any") != "1" || rec.Header().Get("X-Http") != "2" {
+		t.Errorf("health check should carry custom headers, got %d %v", rec.Code, rec.Header())
+	}
+}
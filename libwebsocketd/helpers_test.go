@@ -0,0 +1,68 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// silentLog returns log scope that drops everything.
+func silentLog() *LogScope {
+	return RootLogScope(LogNone, func(*LogScope, LogLevel, string, string, string, ...interface{}) {})
+}
+
+// capturedLog returns log scope that keeps messages of category (or all of
+// them if it's empty) as "LEVEL message" lines.
+func capturedLog(level LogLevel, category string) (*LogScope, *[]string) {
+	logged := new([]string)
+	log := RootLogScope(level, func(l *LogScope, level LogLevel, levelName string, cat string, msg string, args ...interface{}) {
+		if category == "" || cat == category {
+			*logged = append(*logged, levelName+" "+fmt.Sprintf(msg, args...))
+		}
+	})
+	return log, logged
+}
+
+// testDir creates temporary directory with files given as path and content,
+// paths ending with / are created as directories. It's removed once the test
+// is finished.
+func testDir(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "websocketd-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			err = os.MkdirAll(path, 0755)
+		} else if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = ioutil.WriteFile(path, []byte(content), 0644)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// serve passes request through handler and returns the recorded response.
+func serve(h http.Handler, method, path string, hdrs map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range hdrs {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
@@ -12,8 +12,11 @@ import (
 	"net/http"
 	"net/textproto"
 	"net/url"
+	"os"
 	"regexp"
 	"strings"
+	"sync"
+	"text/template"
 
 	"github.com/gorilla/websocket"
 )
@@ -22,9 +25,23 @@ var ForkNotAllowedError = errors.New("too many forks active")
 
 // WebsocketdServer presents http.Handler interface for requests libwebsocketd is handling.
 type WebsocketdServer struct {
-	Config *Config
-	Log    *LogScope
-	forks  chan byte
+	metrics metrics // first for 64-bit alignment of its atomic counters
+
+	Config   *Config
+	Log      *LogScope
+	configMu sync.RWMutex // guards Config and maxForks replaced by Reload
+
+	forksMu    sync.Mutex
+	forks      int            // number of processes currently running
+	maxForks   int            // limit of concurrent processes, zero means unlimited
+	routeForks map[string]int // number of processes running for each Route.Path
+
+	sessionsMu sync.Mutex
+	sessions   map[string]*session // live sessions by WebsocketdHandler.Id
+	sessionsWg sync.WaitGroup
+	draining   bool // set once Shutdown is called
+
+	probe probeState // last result of Config.ReadyProbe
 }
 
 // NewWebsocketdServer creates WebsocketdServer struct with pre-determined config, logscope and maxforks limit
@@ -34,11 +51,32 @@ func NewWebsocketdServer(config *Config, log *LogScope, maxforks int) *Websocket
 		Log:    log,
 	}
 	if maxforks > 0 {
-		mux.forks = make(chan byte, maxforks)
+		mux.maxForks = maxforks
 	}
 	return mux
 }
 
+// Reload replaces configuration and maxforks limit. Requests that are already
+// being served keep configuration they started with.
+func (h *WebsocketdServer) Reload(config *Config, maxforks int) {
+	if maxforks < 0 {
+		maxforks = 0
+	}
+	h.configMu.Lock()
+	h.Config = config
+	h.configMu.Unlock()
+
+	h.forksMu.Lock()
+	h.maxForks = maxforks
+	h.forksMu.Unlock()
+}
+
+func (h *WebsocketdServer) currentConfig() *Config {
+	h.configMu.RLock()
+	defer h.configMu.RUnlock()
+	return h.Config
+}
+
 func splitMimeHeader(s string) (string, string) {
 	p := strings.IndexByte(s, ':')
 	if p < 0 {
@@ -60,42 +98,102 @@ func pushHeaders(h http.Header, hdrs []string) {
 	}
 }
 
-// ServeHTTP muxes between WebSocket handler or 404.
+// ServeHTTP muxes between metrics, health checks, WebSocket handler, CGI handler, DevConsole, Static HTML or 404.
 func (h *WebsocketdServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	log := h.Log.NewLevel(h.Log.LogFunc)
-	log.Associate("url", "http://" + req.Host + req.RequestURI)
+	config := h.currentConfig()
+	log.Associate("url", tellURL(config, "http", req.Host, req.RequestURI))
+
+	// Anything but successful upgrade is answered through w, including errors
+	// that gorilla/websocket writes when handshake fails.
+	pushHeaders(w.Header(), config.Headers)
+	pushHeaders(w.Header(), config.HeadersHTTP)
+
+	switch path := req.URL.Path; {
+	case config.MetricsPath != "" && path == config.MetricsPath:
+		h.ServeMetrics(w, req)
+		return
+	case config.HealthPath != "" && path == config.HealthPath:
+		h.serveHealth(w, log)
+		return
+	case config.ReadyPath != "" && path == config.ReadyPath:
+		h.serveReady(w, config, log)
+		return
+	}
 
-	if h.Config.CommandName != "" {
+	if config.CommandName != "" || config.UsingScriptDir || len(config.Routes) > 0 {
 		hdrs := req.Header
 		upgradeRe := regexp.MustCompile(`(?i)(^|[,\s])Upgrade($|[,\s])`)
 		// WebSocket, limited to size of h.forks
 		if strings.ToLower(hdrs.Get("Upgrade")) == "websocket" && upgradeRe.MatchString(hdrs.Get("Connection")) {
-			if h.noteForkCreated() == nil {
-				defer h.noteForkCompled()
+			if h.isDraining() {
+				log.Access("http", "Server is shutting down, upgrade rejected")
+				h.metrics.upgradeRejected(rejectShutdown)
+				http.Error(w, "503 Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			// route is picked (and path parameters checked) before fork is counted
+			route, urlInfo, err := matchRoute(config.Routes, req.URL.Path)
+			if route != nil {
+				config = route.apply(config)
+				log.Associate("route", route.Path)
+			} else if err != nil {
+				log.Access("session", "NOT FOUND: %s", err)
+				h.metrics.upgradeRejected(rejectNotFound)
+				http.Error(w, "404 Not Found", 404)
+				return
+			} else if config.CommandName == "" && !config.UsingScriptDir {
+				log.Access("session", "NOT FOUND: no route for %s", req.URL.Path)
+				h.metrics.upgradeRejected(rejectNotFound)
+				http.Error(w, "404 Not Found", 404)
+				return
+			}
+			if h.noteForkCreated(route) == nil {
+				defer h.noteForkCompled(route)
 
 				// start figuring out if we even need to upgrade
-				handler, err := NewWebsocketdHandler(h, req, log)
+				handler, err := newWebsocketdHandler(h, config, urlInfo, req, log)
 				if err != nil {
-					log.Access("session", "INTERNAL ERROR: %s", err)
-					http.Error(w, "500 Internal Server Error", 500)
+					if err == ScriptNotFoundError {
+						log.Access("session", "NOT FOUND: %s", err)
+						h.metrics.upgradeRejected(rejectNotFound)
+						http.Error(w, "404 Not Found", 404)
+					} else {
+						log.Access("session", "INTERNAL ERROR: %s", err)
+						h.metrics.upgradeRejected(rejectError)
+						http.Error(w, "500 Internal Server Error", 500)
+					}
 					return
 				}
 
-				var headers = http.Header(make(map[string][]string))
+				var headers http.Header
+				if len(config.Headers)+len(config.HeadersWs) > 0 {
+					headers = http.Header(make(map[string][]string))
+					pushHeaders(headers, config.Headers)
+					pushHeaders(headers, config.HeadersWs)
+				}
+
+				var originErr error
 				upgrader := &websocket.Upgrader{
-					HandshakeTimeout: h.Config.HandshakeTimeout,
+					HandshakeTimeout: config.HandshakeTimeout,
 					CheckOrigin: func(r *http.Request) bool {
 						// backporting previous checkorigin for use in gorilla/websocket for now
-						err := checkOrigin(req, h.Config, log)
-						return err == nil
+						originErr = checkOrigin(req, config, log)
+						return originErr == nil
 					},
 				}
 				conn, err := upgrader.Upgrade(w, req, headers)
 				if err != nil {
 					log.Access("session", "Unable to Upgrade: %s", err)
+					if originErr != nil {
+						h.metrics.upgradeRejected(rejectOrigin)
+					} else {
+						h.metrics.upgradeRejected(rejectError)
+					}
 					http.Error(w, "500 Internal Error", 500)
 					return
 				}
+				h.metrics.upgradeAccepted()
 
 				// old func was used in x/net/websocket style, we reuse it here for gorilla/websocket
 				handler.accept(conn, log)
@@ -103,44 +201,117 @@ func (h *WebsocketdServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 			} else {
 				log.Error("http", "Max of possible forks already active, upgrade rejected")
+				h.metrics.upgradeRejected(rejectMaxForks)
 				http.Error(w, "429 Too Many Requests", http.StatusTooManyRequests)
 			}
 			return
 		}
 	}
 
+	// Dev console (if enabled)
+	if config.DevConsole && (req.Method == "GET" || req.Method == "HEAD") {
+		log.Access("http", "DEVCONSOLE")
+		content := ConsoleContent
+		content = strings.Replace(content, "{{license}}", License, -1)
+		content = strings.Replace(content, "{{addr}}", template.JSEscapeString(tellURL(config, "ws", req.Host, req.RequestURI)), -1)
+		http.ServeContent(w, req, ".html", config.StartupTime, strings.NewReader(content))
+		return
+	}
+
+	// CGI scripts, limited to size of h.forks
+	if config.CgiDir != "" {
+		if urlInfo, err := findScript(req.URL.Path, config.CgiDir); err == nil && isExecutable(urlInfo.FilePath) {
+			if h.noteForkCreated(nil) == nil {
+				defer h.noteForkCompled(nil)
+				h.serveCGI(w, req, config, urlInfo, log)
+			} else {
+				log.Error("http", "Fork not allowed since maxforks amount has been reached. CGI was not run.")
+				http.Error(w, "429 Too Many Requests", http.StatusTooManyRequests)
+			}
+			return
+		}
+	}
+
+	// Static files
+	if config.StaticDir != "" {
+		h.serveStatic(w, req, config, log)
+		return
+	}
+
 	// 404
 	log.Access("http", "NOT FOUND")
 	http.NotFound(w, req)
 }
 
-var canonicalHostname string
+var (
+	canonicalHostname     string
+	canonicalHostnameOnce sync.Once
+)
+
+// CanonicalHostname returns name of the host websocketd is running on, it's used
+// when listening address or request do not specify any hostname.
+func CanonicalHostname() string {
+	canonicalHostnameOnce.Do(func() {
+		var err error
+		canonicalHostname, err = os.Hostname()
+		if err != nil {
+			canonicalHostname = "UNKNOWN"
+		}
+	})
+	return canonicalHostname
+}
 
-func (h *WebsocketdServer) noteForkCreated() error {
-	// note that forks can be nil since the construct could've been created by
-	// someone who is not using NewWebsocketdServer
-	if h.forks != nil {
-		select {
-		case h.forks <- 1:
-			return nil
-		default:
+// TellURL is a helper function that changes http to https or ws to wss in case if SSL is used
+func (h *WebsocketdServer) TellURL(scheme, host, path string) string {
+	return tellURL(h.currentConfig(), scheme, host, path)
+}
+
+func tellURL(config *Config, scheme, host, path string) string {
+	if len(host) > 0 && host[0] == ':' {
+		host = CanonicalHostname() + host
+	}
+	if config.Ssl {
+		return scheme + "s://" + host + path
+	}
+	return scheme + "://" + host + path
+}
+
+func (h *WebsocketdServer) noteForkCreated(route *Route) error {
+	// note that maxForks can be zero since the construct could've been created by
+	// someone who is not using NewWebsocketdServer, it means no limit.
+	// Limit could be lowered by Reload below number of running forks, new forks
+	// are rejected until enough of them complete.
+	h.forksMu.Lock()
+	defer h.forksMu.Unlock()
+	if h.maxForks > 0 && h.forks >= h.maxForks {
+		return ForkNotAllowedError
+	}
+	if route != nil {
+		if route.MaxForks > 0 && h.routeForks[route.Path] >= route.MaxForks {
 			return ForkNotAllowedError
 		}
-	} else {
-		return nil
+		if h.routeForks == nil {
+			h.routeForks = make(map[string]int)
+		}
+		h.routeForks[route.Path]++
 	}
+	h.forks++
+	return nil
 }
 
-func (h *WebsocketdServer) noteForkCompled() {
-	if h.forks != nil { // see comment in noteForkCreated
-		select {
-		case <-h.forks:
-			return
-		default:
-			// This could only happen if the completion handler called more times than creation handler above
-			// Code should be audited to not allow this to happen, it's desired to have test that would
-			// make sure this is impossible but it is not exist yet.
-			panic("Cannot deplet number of allowed forks, something is not right in code!")
+func (h *WebsocketdServer) noteForkCompled(route *Route) {
+	h.forksMu.Lock()
+	defer h.forksMu.Unlock()
+	if h.forks <= 0 || route != nil && h.routeForks[route.Path] <= 0 {
+		// This could only happen if the completion handler called more times than creation handler above
+		// Code should be audited to not allow this to happen, it's desired to have test that would
+		// make sure this is impossible but it is not exist yet.
+		panic("Cannot deplet number of allowed forks, something is not right in code!")
+	}
+	h.forks--
+	if route != nil {
+		if h.routeForks[route.Path]--; h.routeForks[route.Path] == 0 {
+			delete(h.routeForks, route.Path)
 		}
 	}
 }
@@ -172,13 +343,13 @@ func checkOrigin(req *http.Request, config *Config, log *LogScope) (err error) {
 
 	// If some origin restrictions are present:
 	if config.SameOrigin || config.AllowOrigins != nil {
-		originServer, originPort, err := tellHostPort(originParsed.Host)
+		originServer, originPort, err := tellHostPort(originParsed.Host, originParsed.Scheme == "https")
 		if err != nil {
 			log.Access("session", "Origin hostname parsing error: %s", err)
 			return err
 		}
 		if config.SameOrigin {
-			localServer, localPort, err := tellHostPort(req.Host)
+			localServer, localPort, err := tellHostPort(req.Host, req.TLS != nil)
 			if err != nil {
 				log.Access("session", "Request hostname parsing error: %s", err)
 				return err
@@ -202,11 +373,11 @@ func checkOrigin(req *http.Request, config *Config, log *LogScope) (err error) {
 					}
 					allowed = allowed[pos+3:]
 				}
-				allowServer, allowPort, err := tellHostPort(allowed)
+				allowServer, allowPort, err := tellHostPort(allowed, originParsed.Scheme == "https")
 				if err != nil {
 					continue // unparseable
 				}
-				if allowPort == "80" && allowed[len(allowed)-3:] != ":80" {
+				if (allowPort == "80" || allowPort == "443") && !strings.HasSuffix(allowed, ":"+allowPort) {
 					// any port is allowed, host names need to match
 					matchFound = allowServer == originServer
 				} else {
@@ -226,12 +397,16 @@ func checkOrigin(req *http.Request, config *Config, log *LogScope) (err error) {
 	return nil
 }
 
-func tellHostPort(host string) (server, port string, err error) {
+func tellHostPort(host string, ssl bool) (server, port string, err error) {
 	server, port, err = net.SplitHostPort(host)
 	if err != nil {
 		if addrerr, ok := err.(*net.AddrError); ok && strings.Contains(addrerr.Err, "missing port") {
 			server = host
-			port = "80"
+			if ssl {
+				port = "443"
+			} else {
+				port = "80"
+			}
 			err = nil
 		}
 	}
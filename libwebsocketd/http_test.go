@@ -2,6 +2,7 @@ package libwebsocketd
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"net/http"
 	"strings"
@@ -10,6 +11,7 @@ import (
 
 var tellHostPortTests = []struct {
 	src          string
+	ssl          bool
 	server, port string
 }{
 	{"localhost", false, "localhost", "80"},
@@ -20,7 +22,7 @@ var tellHostPortTests = []struct {
 
 func TestTellHostPort(t *testing.T) {
 	for _, testcase := range tellHostPortTests {
-		s, p, e := tellHostPort(testcase.src)
+		s, p, e := tellHostPort(testcase.src, testcase.ssl)
 		if testcase.server == "" {
 			if e == nil {
 				t.Errorf("test case for %#v failed, error was not returned", testcase.src)
@@ -38,7 +40,8 @@ var NoOriginsAllowed = []string{}
 var NoOriginList []string = nil
 
 const (
-	ReqHTTP = iota
+	ReqHTTPS = iota
+	ReqHTTP
 	OriginMustBeSame
 	OriginCouldDiffer
 	ReturnsPass
@@ -47,6 +50,7 @@ const (
 
 var CheckOriginTests = []struct {
 	host    string
+	reqtls  int
 	origin  string
 	same    int
 	allowed []string
@@ -67,6 +71,10 @@ var CheckOriginTests = []struct {
 	{"server.example.com", ReqHTTP, "http://example.com:81", OriginCouldDiffer, []string{"example.com:81"}, ReturnsPass, "origin allowed port 81 match"},
 	{"server.example.com", ReqHTTP, "null", OriginCouldDiffer, NoOriginList, ReturnsPass, "any origin allowed, even null"},
 	{"server.example.com", ReqHTTP, "", OriginCouldDiffer, NoOriginList, ReturnsPass, "any origin allowed, even empty"},
+	{"server.example.com", ReqHTTPS, "https://server.example.com", OriginMustBeSame, NoOriginList, ReturnsPass, "same origin match over TLS"},
+	{"server.example.com", ReqHTTP, "https://server.example.com", OriginMustBeSame, NoOriginList, ReturnsError, "same origin scheme mismatch"},
+	{"server.example.com", ReqHTTPS, "https://example.com", OriginCouldDiffer, []string{"https://example.com"}, ReturnsPass, "origin allowed https match"},
+	{"server.example.com", ReqHTTPS, "http://example.com", OriginCouldDiffer, []string{"https://example.com"}, ReturnsError, "origin allowed https mismatch"},
 }
 
 // CONVERT GORILLA
@@ -90,6 +98,10 @@ Sec-WebSocket-Version: 13
 			t.Fatal("request", err)
 		}
 
+		if testcase.reqtls == ReqHTTPS { // Fake TLS
+			req.TLS = &tls.ConnectionState{}
+		}
+
 		log := new(LogScope)
 		log.LogFunc = func(*LogScope, LogLevel, string, string, string, ...interface{}) {}
 
@@ -67,6 +67,24 @@ func (l *LogScope) Fatal(category string, msg string, args ...interface{}) {
 	l.LogFunc(l, LogFatal, "FATAL", category, msg, args...)
 }
 
+// Log writes message at level given as value, LogNone drops it.
+func (l *LogScope) Log(level LogLevel, category string, msg string, args ...interface{}) {
+	switch level {
+	case LogDebug:
+		l.Debug(category, msg, args...)
+	case LogTrace:
+		l.Trace(category, msg, args...)
+	case LogAccess:
+		l.Access(category, msg, args...)
+	case LogInfo:
+		l.Info(category, msg, args...)
+	case LogError:
+		l.Error(category, msg, args...)
+	case LogFatal:
+		l.Fatal(category, msg, args...)
+	}
+}
+
 func (parent *LogScope) NewLevel(logFunc LogFunc) *LogScope {
 	return &LogScope{
 		Parent:     parent,
@@ -0,0 +1,215 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Reasons WebSocket upgrade could be rejected for, reported as labels of
+// websocketd_upgrades_rejected_total.
+const (
+	rejectOrigin   = "origin"   // 403, origin policy
+	rejectMaxForks = "maxforks" // 429, --maxforks or route limit reached
+	rejectNotFound = "notfound" // 404, no command, route or script for the path
+	rejectShutdown = "shutdown" // 503, server is draining
+	rejectError    = "error"    // anything else that failed handshake
+)
+
+// Stages of ProcessEndpoint termination, the last one reached is counted.
+const (
+	stageStdinClose = "stdin_close"
+	stageSigint     = "sigint"
+	stageSigterm    = "sigterm"
+	stageSigkill    = "sigkill"
+	stageUnkillable = "unkillable" // still running after SIGKILL
+)
+
+// Directions of traffic between client and process.
+const (
+	directionIn  = 0 // client to process
+	directionOut = 1 // process to client
+)
+
+var (
+	rejectReasons     = []string{rejectOrigin, rejectMaxForks, rejectNotFound, rejectShutdown, rejectError}
+	terminationStages = []string{stageStdinClose, stageSigint, stageSigterm, stageSigkill, stageUnkillable}
+	directionNames    = [2]string{"in", "out"}
+
+	// upper bounds of session duration histogram buckets in seconds
+	durationBuckets = []float64{0.1, 0.5, 1, 5, 15, 60, 300, 900, 3600, 14400}
+)
+
+// metrics accumulates counters of server activity since it started. Message
+// counters are updated with atomics since they are hit for every message, the
+// rest is guarded by mu.
+type metrics struct {
+	messages [2]int64
+	bytes    [2]int64
+
+	mu               sync.Mutex
+	upgradesAccepted int64
+	upgradesRejected map[string]int64
+	spawnFailures    int64
+	exitCodes        map[string]int64
+	terminations     map[string]int64
+	durationCounts   []int64 // per bucket of durationBuckets, not cumulative
+	durationSum      float64
+	durationCount    int64
+}
+
+func (m *metrics) upgradeAccepted() {
+	m.mu.Lock()
+	m.upgradesAccepted++
+	m.mu.Unlock()
+}
+
+func (m *metrics) upgradeRejected(reason string) {
+	m.mu.Lock()
+	if m.upgradesRejected == nil {
+		m.upgradesRejected = make(map[string]int64)
+	}
+	m.upgradesRejected[reason]++
+	m.mu.Unlock()
+}
+
+func (m *metrics) spawnFailed() {
+	m.mu.Lock()
+	m.spawnFailures++
+	m.mu.Unlock()
+}
+
+// processExited counts exit code (or "signal" if process was killed) and the
+// stage of termination that finished the process.
+func (m *metrics) processExited(stage string, code int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.terminations == nil {
+		m.terminations = make(map[string]int64)
+	}
+	m.terminations[stage]++
+	if stage == stageUnkillable {
+		return
+	}
+	if m.exitCodes == nil {
+		m.exitCodes = make(map[string]int64)
+	}
+	if code < 0 {
+		m.exitCodes["signal"]++
+	} else {
+		m.exitCodes[strconv.Itoa(code)]++
+	}
+}
+
+func (m *metrics) message(direction int, size int) {
+	atomic.AddInt64(&m.messages[direction], 1)
+	atomic.AddInt64(&m.bytes[direction], int64(size))
+}
+
+func (m *metrics) sessionFinished(d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.durationCounts == nil {
+		m.durationCounts = make([]int64, len(durationBuckets))
+	}
+	seconds := d.Seconds()
+	for i, le := range durationBuckets {
+		if seconds <= le {
+			m.durationCounts[i]++
+			break
+		}
+	}
+	m.durationSum += seconds
+	m.durationCount++
+}
+
+// ServeMetrics answers with counters of server activity in Prometheus text
+// exposition format.
+func (h *WebsocketdServer) ServeMetrics(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if req.Method == "HEAD" {
+		return
+	}
+	h.writeMetrics(w)
+}
+
+func (h *WebsocketdServer) writeMetrics(out io.Writer) {
+	h.sessionsMu.Lock()
+	sessions := len(h.sessions)
+	h.sessionsMu.Unlock()
+	h.forksMu.Lock()
+	forks, maxForks := h.forks, h.maxForks
+	h.forksMu.Unlock()
+
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	header := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	header("websocketd_sessions_active", "gauge", "WebSocket sessions currently running.")
+	fmt.Fprintf(w, "websocketd_sessions_active %d\n", sessions)
+	header("websocketd_forks_active", "gauge", "Processes counted against --maxforks.")
+	fmt.Fprintf(w, "websocketd_forks_active %d\n", forks)
+	header("websocketd_forks_max", "gauge", "Limit of concurrent processes, 0 means unlimited.")
+	fmt.Fprintf(w, "websocketd_forks_max %d\n", maxForks)
+
+	m := &h.metrics
+	header("websocketd_messages_total", "counter", "Messages passed from clients to processes (in) and back (out).")
+	for d, name := range directionNames {
+		fmt.Fprintf(w, "websocketd_messages_total{direction=%q} %d\n", name, atomic.LoadInt64(&m.messages[d]))
+	}
+	header("websocketd_message_bytes_total", "counter", "Bytes passed from clients to processes (in) and back (out).")
+	for d, name := range directionNames {
+		fmt.Fprintf(w, "websocketd_message_bytes_total{direction=%q} %d\n", name, atomic.LoadInt64(&m.bytes[d]))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	header("websocketd_upgrades_accepted_total", "counter", "WebSocket upgrades that started a process.")
+	fmt.Fprintf(w, "websocketd_upgrades_accepted_total %d\n", m.upgradesAccepted)
+	header("websocketd_upgrades_rejected_total", "counter", "WebSocket upgrades rejected, by reason.")
+	for _, reason := range rejectReasons {
+		fmt.Fprintf(w, "websocketd_upgrades_rejected_total{reason=%q} %d\n", reason, m.upgradesRejected[reason])
+	}
+
+	header("websocketd_process_spawn_failures_total", "counter", "Processes that could not be started.")
+	fmt.Fprintf(w, "websocketd_process_spawn_failures_total %d\n", m.spawnFailures)
+	header("websocketd_process_exits_total", "counter", "Finished processes by exit code, \"signal\" if process was killed.")
+	codes := make([]string, 0, len(m.exitCodes))
+	for code := range m.exitCodes {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	for _, code := range codes {
+		fmt.Fprintf(w, "websocketd_process_exits_total{code=%q} %d\n", code, m.exitCodes[code])
+	}
+	header("websocketd_process_terminations_total", "counter", "Finished processes by the last termination stage reached.")
+	for _, stage := range terminationStages {
+		fmt.Fprintf(w, "websocketd_process_terminations_total{stage=%q} %d\n", stage, m.terminations[stage])
+	}
+
+	header("websocketd_session_duration_seconds", "histogram", "Duration of WebSocket sessions.")
+	cumulative := int64(0)
+	for i, le := range durationBuckets {
+		if m.durationCounts != nil {
+			cumulative += m.durationCounts[i]
+		}
+		fmt.Fprintf(w, "websocketd_session_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(le, 'g', -1, 64), cumulative)
+	}
+	fmt.Fprintf(w, "websocketd_session_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.durationCount)
+	fmt.Fprintf(w, "websocketd_session_duration_seconds_sum %s\n", strconv.FormatFloat(m.durationSum, 'g', -1, 64))
+	fmt.Fprintf(w, "websocketd_session_duration_seconds_count %d\n", m.durationCount)
+}
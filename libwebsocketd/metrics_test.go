@@ -0,0 +1,51 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	h := NewWebsocketdServer(&Config{}, nil, 3)
+	h.noteForkCreated(nil)
+	h.metrics.upgradeAccepted()
+	h.metrics.upgradeRejected(rejectOrigin)
+	h.metrics.processExited(stageSigterm, -1)
+	h.metrics.processExited(stageStdinClose, 0)
+	h.metrics.message(directionIn, 5)
+	h.metrics.message(directionIn, 7)
+	h.metrics.sessionFinished(2 * time.Second)
+	h.metrics.sessionFinished(time.Hour)
+
+	var b strings.Builder
+	h.writeMetrics(&b)
+	out := b.String()
+	for _, line := range []string{
+		"websocketd_forks_active 1",
+		"websocketd_forks_max 3",
+		"websocketd_upgrades_accepted_total 1",
+		`websocketd_upgrades_rejected_total{reason="origin"} 1`,
+		`websocketd_upgrades_rejected_total{reason="maxforks"} 0`,
+		`websocketd_process_exits_total{code="0"} 1`,
+		`websocketd_process_exits_total{code="signal"} 1`,
+		`websocketd_process_terminations_total{stage="sigterm"} 1`,
+		`websocketd_messages_total{direction="in"} 2`,
+		`websocketd_message_bytes_total{direction="in"} 12`,
+		`websocketd_message_bytes_total{direction="out"} 0`,
+		`websocketd_session_duration_seconds_bucket{le="1"} 0`,
+		`websocketd_session_duration_seconds_bucket{le="5"} 1`,
+		`websocketd_session_duration_seconds_bucket{le="3600"} 2`,
+		`websocketd_session_duration_seconds_bucket{le="+Inf"} 2`,
+		"websocketd_session_duration_seconds_count 2",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("metrics output has no %q line:\n%s", line, out)
+		}
+	}
+}
@@ -7,9 +7,10 @@ package libwebsocketd
 
 import (
 	"bufio"
-	"fmt"
+	"errors"
 	"io"
 	"os"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -20,6 +21,13 @@ type ProcessEndpoint struct {
 	output    chan []byte
 	log       *LogScope
 	bin       bool
+	terminate sync.Once
+	stderr    stderrHandling
+
+	// set by Terminate: the last stage of termination reached and exit code
+	// of the process, -1 if it was killed by signal
+	stage    string
+	exitCode int
 }
 
 func NewProcessEndpoint(process *LaunchedProcess, bin bool, log *LogScope) *ProcessEndpoint {
@@ -28,13 +36,28 @@ func NewProcessEndpoint(process *LaunchedProcess, bin bool, log *LogScope) *Proc
 		output:  make(chan []byte),
 		log:     log,
 		bin:     bin,
+		stderr:  stderrHandling{level: LogError, category: defaultStderrCategory, limit: defaultStderrLineLimit},
 	}
 }
 
+// Terminate stops the process, it's safe to call it multiple times and from different
+// goroutines, all callers return once process is gone.
 func (pe *ProcessEndpoint) Terminate() {
+	pe.terminate.Do(pe.signalLadder)
+}
+
+func (pe *ProcessEndpoint) signalLadder() {
 	terminated := make(chan struct{})
 	go func() { pe.process.cmd.Wait(); terminated <- struct{}{} }()
 
+	pe.stage, pe.exitCode = stageStdinClose, -1
+	defer func() {
+		// ProcessState is there unless process outlived all the signals
+		if pe.stage != stageUnkillable {
+			pe.exitCode = pe.process.cmd.ProcessState.ExitCode()
+		}
+	}()
+
 	// for some processes this is enough to finish them...
 	pe.process.stdin.Close()
 
@@ -46,6 +69,7 @@ func (pe *ProcessEndpoint) Terminate() {
 	case <-time.After(100*time.Millisecond + pe.closetime):
 	}
 
+	pe.stage = stageSigint
 	err := pe.process.cmd.Process.Signal(syscall.SIGINT)
 	if err != nil {
 		// process is done without this, great!
@@ -59,6 +83,7 @@ func (pe *ProcessEndpoint) Terminate() {
 	case <-time.After(250*time.Millisecond + pe.closetime):
 	}
 
+	pe.stage = stageSigterm
 	err = pe.process.cmd.Process.Signal(syscall.SIGTERM)
 	if err != nil {
 		// process is done without this, great!
@@ -72,10 +97,12 @@ func (pe *ProcessEndpoint) Terminate() {
 	case <-time.After(500*time.Millisecond + pe.closetime):
 	}
 
+	// if SIGKILL fails process has usually finished just before it, exit
+	// status is still collected below
+	pe.stage = stageSigkill
 	err = pe.process.cmd.Process.Kill()
-	if err != nil {
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
 		pe.log.Error("process", "SIGKILL unsuccessful to %v: %s", pe.process.cmd.Process.Pid, err)
-		return
 	}
 
 	select {
@@ -86,6 +113,7 @@ func (pe *ProcessEndpoint) Terminate() {
 	}
 
 	pe.log.Error("process", "SIGKILL did not terminate %v!", pe.process.cmd.Process.Pid)
+	pe.stage = stageUnkillable
 }
 
 func (pe *ProcessEndpoint) Output() chan []byte {
@@ -141,19 +169,7 @@ func (pe *ProcessEndpoint) process_binout() {
 }
 
 func (pe *ProcessEndpoint) log_stderr() {
-	bufstderr := bufio.NewReader(pe.process.stderr)
-	for {
-		buf, err := bufstderr.ReadSlice('\n')
-		if err != nil {
-			if err != io.EOF {
-				pe.log.Error("process", "Unexpected error while reading STDERR from process: %s", err)
-			} else {
-				pe.log.Debug("process", "Process STDERR closed")
-			}
-			break
-		}
-		fmt.Fprintf(os.Stderr, "%s\n", string(trimEOL(buf)))
-	}
+	pe.stderr.read(pe.process.stderr, pe.log)
 }
 
 // trimEOL cuts unixy style \n and windowsy style \r\n suffix from the string
@@ -0,0 +1,192 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// RecordExt is extension of session transcript files
+const RecordExt = ".jsonl"
+
+// RecordEntry is a line of session transcript. Transcripts written to
+// Config.RecordDir are JSON Lines files named after WebsocketdHandler.Id with
+// RecordExt extension. Every line is an object with "type" field:
+//
+//	{"type":"start", "time":..., "id":..., "url":..., "remote":..., "command":...,
+//	 "args":[...], "pid":1234, "binary":false, "env":["KEY=value", ...]}
+//	{"type":"message", "t":0.25, "dir":"in", "text":"hello"}
+//	{"type":"message", "t":0.26, "dir":"out", "data":"aGVsbG8="}
+//	{"type":"truncated", "t":9.5, "limit":10485760}
+//	{"type":"end", "t":12.1, "time":..., "closed_by":"client", "close_code":1000,
+//	 "close_reason":"", "stage":"stdin_close", "exit_code":0}
+//
+// "time" is RFC3339 timestamp and "t" is number of seconds since the session
+// started. Messages go from client to process ("in") or back ("out"), "text" is
+// used for text messages exactly as they were sent over WebSocket and "data"
+// holds base64 of binary ones. "closed_by" is "client", "server" (shutdown or
+// admin API) or "process" (it finished first, connection is closed without
+// close frame). Once file grows past RecordLimit, messages are not written
+// anymore and "truncated" line tells about that, "end" line is always there.
+// Process had to be started for the file to be written.
+type RecordEntry struct {
+	Type string `json:"type"`
+
+	// start
+	Time    *time.Time `json:"time,omitempty"`
+	Id      string     `json:"id,omitempty"`
+	URL     string     `json:"url,omitempty"`
+	Remote  string     `json:"remote,omitempty"`
+	Command string     `json:"command,omitempty"`
+	Args    []string   `json:"args,omitempty"`
+	Pid     int        `json:"pid,omitempty"`
+	Binary  bool       `json:"binary,omitempty"`
+	Env     []string   `json:"env,omitempty"`
+
+	// message, truncated and end
+	T     float64 `json:"t"`
+	Dir   string  `json:"dir,omitempty"`
+	Text  *string `json:"text,omitempty"`
+	Data  []byte  `json:"data,omitempty"`
+	Limit int64   `json:"limit,omitempty"`
+
+	// end
+	ClosedBy    string `json:"closed_by,omitempty"`
+	CloseCode   int    `json:"close_code,omitempty"`
+	CloseReason string `json:"close_reason,omitempty"`
+	Stage       string `json:"stage,omitempty"`
+	ExitCode    *int   `json:"exit_code,omitempty"`
+}
+
+// Payload returns message as it went over WebSocket.
+func (e *RecordEntry) Payload() []byte {
+	if e.Text != nil {
+		return []byte(*e.Text)
+	}
+	return e.Data
+}
+
+// recorder writes transcript of a single session
+type recorder struct {
+	mu        sync.Mutex
+	f         *os.File
+	started   time.Time
+	binary    bool
+	limit     int64
+	written   int64
+	truncated bool
+}
+
+func newRecorder(wsh *WebsocketdHandler, pid int, started time.Time) (*recorder, error) {
+	path := filepath.Join(wsh.config.RecordDir, wsh.Id+RecordExt)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600) // environment could hold secrets
+	if err != nil {
+		return nil, err
+	}
+	r := &recorder{f: f, started: started, binary: wsh.config.Binary, limit: wsh.config.RecordLimit}
+	r.write(&RecordEntry{
+		Type:    "start",
+		Time:    &started,
+		Id:      wsh.Id,
+		URL:     wsh.url,
+		Remote:  wsh.RemoteInfo.Addr,
+		Command: wsh.command,
+		Args:    wsh.config.CommandArgs,
+		Pid:     pid,
+		Binary:  wsh.config.Binary,
+		Env:     wsh.Env,
+	})
+	return r, nil
+}
+
+func (r *recorder) since() float64 {
+	return float64(time.Since(r.started)/time.Microsecond) / 1e6
+}
+
+func (r *recorder) write(e *RecordEntry) {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	r.writeLine(line)
+}
+
+func (r *recorder) writeLine(line []byte) {
+	n, _ := r.f.Write(append(line, '\n'))
+	r.written += int64(n)
+}
+
+// message records msg passed in direction, text messages from client carry
+// newline added for the process which is not a part of WebSocket message.
+func (r *recorder) message(direction int, msg []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.truncated {
+		return
+	}
+	e := &RecordEntry{Type: "message", T: r.since(), Dir: directionNames[direction]}
+	if r.binary {
+		e.Data = msg
+	} else {
+		if direction == directionIn {
+			msg = trimEOL(msg)
+		}
+		text := string(msg)
+		e.Text = &text
+	}
+
+	line, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if r.limit > 0 && r.written+int64(len(line))+1 > r.limit {
+		r.truncated = true
+		r.write(&RecordEntry{Type: "truncated", T: r.since(), Limit: r.limit})
+		return
+	}
+	r.writeLine(line)
+}
+
+// finish writes the end line and closes the file.
+func (r *recorder) finish(closedBy string, code int, reason string, process *ProcessEndpoint) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	e := &RecordEntry{
+		Type:        "end",
+		T:           r.since(),
+		Time:        &now,
+		ClosedBy:    closedBy,
+		CloseCode:   code,
+		CloseReason: reason,
+		Stage:       process.stage,
+	}
+	if process.stage != stageUnkillable {
+		e.ExitCode = &process.exitCode
+	}
+	r.write(e)
+	r.f.Close()
+}
+
+// closeDetails tells who ended the session and with what close code and reason.
+func closeDetails(s *session, ws *WebSocketEndpoint, processEnded bool) (closedBy string, code int, reason string) {
+	if code, reason, ok := s.closedByServer(); ok {
+		return "server", code, reason
+	}
+	if processEnded {
+		return "process", 0, ""
+	}
+	if ce, ok := ws.readError().(*websocket.CloseError); ok {
+		return "client", ce.Code, ce.Text
+	}
+	return "client", websocket.CloseAbnormalClosure, ""
+}
@@ -0,0 +1,74 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRecorder(t *testing.T) {
+	dir := testDir(t, nil)
+
+	wsh := &WebsocketdHandler{
+		Id:         "abc",
+		Env:        []string{"A=1"},
+		RemoteInfo: &RemoteInfo{Addr: "127.0.0.1"},
+		command:    "/bin/cat",
+		config:     &Config{RecordDir: dir, RecordLimit: 400},
+	}
+	r, err := newRecorder(wsh, 42, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = newRecorder(wsh, 42, time.Now()); err == nil {
+		t.Error("existing transcript should not be overwritten")
+	}
+	r.message(directionIn, []byte("hello\n"))
+	r.message(directionOut, []byte("hello"))
+	for i := 0; i < 10; i++ {
+		r.message(directionOut, []byte("some longer message to get over the limit"))
+	}
+	r.finish("server", 4001, "kicked", &ProcessEndpoint{stage: stageSigint, exitCode: 130})
+
+	f, err := os.Open(filepath.Join(dir, "abc"+RecordExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []RecordEntry
+	for sc := bufio.NewScanner(f); sc.Scan(); {
+		var e RecordEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("bad line %q: %s", sc.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+
+	if len(entries) < 5 {
+		t.Fatalf("too few entries: %+v", entries)
+	}
+	if e := entries[0]; e.Type != "start" || e.Id != "abc" || e.Pid != 42 || e.Command != "/bin/cat" || len(e.Env) != 1 {
+		t.Errorf("unexpected start entry %+v", e)
+	}
+	if e := entries[1]; e.Type != "message" || e.Dir != "in" || string(e.Payload()) != "hello" {
+		t.Errorf("unexpected first message %+v", e)
+	}
+	if e := entries[2]; e.Type != "message" || e.Dir != "out" || string(e.Payload()) != "hello" {
+		t.Errorf("unexpected second message %+v", e)
+	}
+	if e := entries[len(entries)-2]; e.Type != "truncated" || e.Limit != 400 {
+		t.Errorf("expected truncated entry, got %+v", e)
+	}
+	e := entries[len(entries)-1]
+	if e.Type != "end" || e.ClosedBy != "server" || e.CloseCode != 4001 || e.CloseReason != "kicked" || e.Stage != stageSigint || e.ExitCode == nil || *e.ExitCode != 130 {
+		t.Errorf("unexpected end entry %+v", e)
+	}
+}
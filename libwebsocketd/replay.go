@@ -0,0 +1,170 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// maxRecordLine is the longest transcript line ReadRecord accepts, binary
+// messages could be up to 10MB before base64.
+const maxRecordLine = 16 * 1024 * 1024
+
+// ReadRecord reads session transcript written to Config.RecordDir.
+func ReadRecord(r io.Reader) ([]RecordEntry, error) {
+	var entries []RecordEntry
+	sc := bufio.NewScanner(r)
+	sc.Buffer(nil, maxRecordLine)
+	for n := 1; sc.Scan(); n++ {
+		var e RecordEntry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("line %d: %s", n, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 || entries[0].Type != "start" {
+		return nil, errors.New("transcript does not begin with start line")
+	}
+	return entries, nil
+}
+
+// ReplayConfig tells how to replay a transcript.
+type ReplayConfig struct {
+	CommandName string        // Command to start instead of the recorded one, if set.
+	CommandArgs []string      // Its arguments.
+	Fast        bool          // Feed messages as fast as possible instead of keeping original timing.
+	Timeout     time.Duration // Time to wait for output once all messages were fed.
+}
+
+// ReplayResult is what process did when transcript was replayed.
+type ReplayResult struct {
+	Expected [][]byte // messages sent by process in the transcript
+	Output   [][]byte // messages sent by replayed process
+
+	ExpectedExitCode *int   // nil if it's not known
+	ExitCode         int    // -1 if process was killed by signal
+	Stage            string // termination stage, see process exit metrics
+
+	Truncated bool // transcript hit --recordlimit, only its first part was replayed
+	TimedOut  bool // process did not send all expected messages or finish in time
+}
+
+// Replay starts command with environment from transcript, sends it messages
+// that came from the client and collects the output. Like the original session
+// it ends when the process finishes or, if client or server closed it, once
+// expected number of messages was received (the rest would not get to the
+// client either).
+func Replay(entries []RecordEntry, config *ReplayConfig, log *LogScope) (*ReplayResult, error) {
+	if len(entries) == 0 || entries[0].Type != "start" {
+		return nil, errors.New("transcript does not begin with start line")
+	}
+	start := entries[0]
+
+	var inputs []RecordEntry
+	var end *RecordEntry
+	result := &ReplayResult{}
+	for i := range entries[1:] {
+		e := &entries[i+1]
+		switch e.Type {
+		case "message":
+			if e.Dir == directionNames[directionIn] {
+				inputs = append(inputs, *e)
+			} else {
+				result.Expected = append(result.Expected, e.Payload())
+			}
+		case "truncated":
+			result.Truncated = true
+		case "end":
+			end = e
+		}
+	}
+	processEnds := end != nil && end.ClosedBy == "process" && !result.Truncated
+	if end != nil {
+		result.ExpectedExitCode = end.ExitCode
+	}
+
+	command, args := start.Command, start.Args
+	if config.CommandName != "" {
+		command, args = config.CommandName, config.CommandArgs
+	}
+	launched, err := launchCmd(command, args, start.Env)
+	if err != nil {
+		return nil, err
+	}
+	process := NewProcessEndpoint(launched, start.Binary, log)
+	process.StartReading()
+
+	began := time.Now()
+	wait := func(t float64) {
+		if !config.Fast {
+			time.Sleep(time.Until(began.Add(time.Duration(t * float64(time.Second)))))
+		}
+	}
+
+	fed := make(chan struct{})
+	go func() {
+		defer close(fed)
+		for _, e := range inputs {
+			wait(e.T)
+			msg := e.Payload()
+			if !start.Binary {
+				msg = append(msg, '\n') // as websocket endpoint does
+			}
+			process.Send(msg)
+		}
+		if end != nil && !result.Truncated {
+			wait(end.T)
+		}
+	}()
+
+	output := process.Output()
+	var timeout <-chan time.Time
+collect:
+	for {
+		select {
+		case msg, ok := <-output:
+			if !ok {
+				output = nil
+				break collect
+			}
+			result.Output = append(result.Output, msg)
+			if timeout != nil && !processEnds && len(result.Output) >= len(result.Expected) {
+				break collect
+			}
+		case <-fed:
+			fed = nil
+			if !processEnds && len(result.Output) >= len(result.Expected) {
+				break collect
+			}
+			timeout = time.After(config.Timeout)
+		case <-timeout:
+			result.TimedOut = true
+			break collect
+		}
+	}
+
+	if output != nil {
+		go func() {
+			for range output {
+			}
+		}()
+	}
+	process.Terminate()
+
+	if result.Truncated && len(result.Output) > len(result.Expected) {
+		result.Output = result.Output[:len(result.Expected)]
+	}
+	result.Stage, result.ExitCode = process.stage, process.exitCode
+	return result, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const replayTranscript = `{"type":"start","command":"sh","args":["-c","while read l; do echo \"$l$SUFFIX\"; done"],"env":["SUFFIX=!"]}
+{"type":"message","t":0.01,"dir":"in","text":"one"}
+{"type":"message","t":0.01,"dir":"out","text":"one!"}
+{"type":"message","t":0.05,"dir":"in","text":"two"}
+{"type":"message","t":0.05,"dir":"out","text":"two!"}
+{"type":"end","t":0.06,"closed_by":"client","close_code":1000,"stage":"stdin_close","exit_code":0}
+`
+
+func TestReplay(t *testing.T) {
+	entries, err := ReadRecord(strings.NewReader(replayTranscript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := silentLog()
+
+	for _, fast := range []bool{false, true} {
+		began := time.Now()
+		res, err := Replay(entries, &ReplayConfig{Fast: fast, Timeout: time.Second}, log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fmt.Sprintf("%q", res.Output) != fmt.Sprintf("%q", res.Expected) || len(res.Expected) != 2 {
+			t.Errorf("fast=%v: output %q, expected %q", fast, res.Output, res.Expected)
+		}
+		if res.TimedOut || res.Stage != stageStdinClose || res.ExitCode != 0 || *res.ExpectedExitCode != 0 {
+			t.Errorf("fast=%v: unexpected result %+v", fast, res)
+		}
+		if took := time.Since(began); !fast && took < 60*time.Millisecond {
+			t.Errorf("original timing was not kept, replay took %s", took)
+		}
+	}
+
+	res, err := Replay(entries, &ReplayConfig{
+		CommandName: "sh", CommandArgs: []string{"-c", "read l; echo changed; cat >/dev/null"}, Fast: true, Timeout: 50 * time.Millisecond,
+	}, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprintf("%q", res.Output) != `["changed"]` || !res.TimedOut {
+		t.Errorf("unexpected result of changed command %+v", res)
+	}
+
+	if _, err := ReadRecord(strings.NewReader(`{"type":"message","t":1,"dir":"in","text":"x"}`)); err == nil {
+		t.Error("transcript without start line was accepted")
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// RouteConstraintError is returned when url path fits route pattern but one of
+// path parameters does not satisfy its constraint.
+var RouteConstraintError = errors.New("path parameter constraint failed")
+
+// Route sends WebSocket upgrades for matching URL paths to its own command. Its
+// settings replace ones of Config for these connections.
+//
+// Path is matched segment by segment: "/chat" matches only itself, "/chat/" matches
+// itself and everything below it and "*" segment matches any single segment.
+// "{name}" segment matches any single segment too and passes it to the process
+// as PATH_PARAM_NAME variable, "{name:int}", "{name:uuid}" and "{name:REGEXP}"
+// restrict what the segment could be.
+type Route struct {
+	Path         string
+	CommandName  string   // Command to execute.
+	CommandArgs  []string // Additional args to pass to command.
+	Binary       bool     // Use binary communication.
+	MaxForks     int      // Limit of concurrent processes of this route, zero means only server wide limit applies.
+	CloseMs      uint     // Milliseconds to start sending signals.
+	AllowOrigins []string // List of allowed origin addresses for websocket upgrade.
+	SameOrigin   bool     // Requires websocket upgrades to be performed from same origin only.
+	Env          []string // Environment variables ("key=value") added to Config.Env.
+
+	compileOnce sync.Once
+	compileErr  error
+	segments    []routeSegment
+}
+
+// routeSegment is compiled piece of Route.Path between slashes.
+type routeSegment struct {
+	literal string
+	param   string // name of {param}, empty for literal and * segments
+	any     bool   // * or {param} without constraint
+	check   func(string) bool
+}
+
+var (
+	paramNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	uuidRe      = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+)
+
+// Compile validates Path pattern. It's done on the first match anyway, calling it
+// beforehand lets mistakes be reported on startup.
+func (r *Route) Compile() error {
+	r.compileOnce.Do(func() {
+		r.segments, r.compileErr = compileRoutePath(r.Path)
+	})
+	return r.compileErr
+}
+
+func compileRoutePath(path string) ([]routeSegment, error) {
+	parts := splitPath(path)
+	segments := make([]routeSegment, len(parts))
+	seen := make(map[string]bool)
+	for i, part := range parts {
+		switch {
+		case part == "*":
+			segments[i].any = true
+		case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+			name, constraint := part[1:len(part)-1], ""
+			if p := strings.IndexByte(name, ':'); p >= 0 {
+				name, constraint = name[:p], name[p+1:]
+			}
+			if !paramNameRe.MatchString(name) {
+				return nil, fmt.Errorf("bad path parameter name '%s' in %s", name, path)
+			}
+			key := strings.ToUpper(name)
+			if seen[key] {
+				return nil, fmt.Errorf("path parameter '%s' is used twice in %s", name, path)
+			}
+			seen[key] = true
+			segments[i].param = name
+
+			switch constraint {
+			case "":
+				segments[i].any = true
+			case "int":
+				segments[i].check = func(s string) bool {
+					_, err := strconv.ParseInt(s, 10, 64)
+					return err == nil
+				}
+			case "uuid":
+				segments[i].check = uuidRe.MatchString
+			default:
+				re, err := regexp.Compile("^(?:" + constraint + ")$")
+				if err != nil {
+					return nil, fmt.Errorf("bad constraint of path parameter '%s' in %s: %s", name, path, err)
+				}
+				segments[i].check = re.MatchString
+			}
+		case strings.ContainsAny(part, "{}"):
+			return nil, fmt.Errorf("path parameter should take whole segment in %s", path)
+		default:
+			segments[i].literal = part
+		}
+	}
+	return segments, nil
+}
+
+// match checks if url path is served by route, the part of path that matched
+// becomes SCRIPT_NAME and the rest is PATH_INFO. RouteConstraintError is
+// returned when path fits the pattern but not constraints of its parameters.
+func (r *Route) match(path string) (*URLInfo, error) {
+	if err := r.Compile(); err != nil {
+		return nil, err
+	}
+	pattern := r.segments
+	segments := splitPath(path)
+	prefix := strings.HasSuffix(r.Path, "/")
+
+	if len(segments) < len(pattern) || !prefix && len(segments) != len(pattern) {
+		return nil, ScriptNotFoundError
+	}
+	var params []PathParam
+	constraintFailed := false
+	for i, p := range pattern {
+		switch {
+		case p.param == "" && !p.any:
+			if p.literal != segments[i] {
+				return nil, ScriptNotFoundError
+			}
+		case p.check != nil && !p.check(segments[i]):
+			constraintFailed = true
+		}
+		if p.param != "" {
+			params = append(params, PathParam{p.param, segments[i]})
+		}
+	}
+	if constraintFailed {
+		return nil, RouteConstraintError
+	}
+
+	scriptPath := "/" + strings.Join(segments[:len(pattern)], "/")
+	pathInfo := ""
+	if len(segments) > len(pattern) {
+		pathInfo = "/" + strings.Join(segments[len(pattern):], "/")
+	}
+	return &URLInfo{ScriptPath: scriptPath, PathInfo: pathInfo, PathParams: params}, nil
+}
+
+// specificity orders routes matching the same path: more segments first, then
+// exact paths before prefixes, then less wildcards (including parameters).
+func (r *Route) specificity() (segments int, exact bool, wildcards int) {
+	for _, p := range r.segments {
+		if p.param != "" || p.any {
+			wildcards++
+		}
+	}
+	return len(r.segments), !strings.HasSuffix(r.Path, "/"), wildcards
+}
+
+func (r *Route) moreSpecificThan(other *Route) bool {
+	s1, e1, w1 := r.specificity()
+	s2, e2, w2 := other.specificity()
+	if s1 != s2 {
+		return s1 > s2
+	}
+	if e1 != e2 {
+		return e1
+	}
+	return w1 < w2
+}
+
+// apply makes configuration of connections served by route.
+func (r *Route) apply(config *Config) *Config {
+	c := *config
+	c.CommandName = r.CommandName
+	c.CommandArgs = r.CommandArgs
+	c.UsingScriptDir = false
+	c.ScriptDir = ""
+	c.Binary = r.Binary
+	c.CloseMs = r.CloseMs
+	c.AllowOrigins = r.AllowOrigins
+	c.SameOrigin = r.SameOrigin
+	if len(r.Env) > 0 {
+		c.Env = append(append(make([]string, 0, len(config.Env)+len(r.Env)), config.Env...), r.Env...)
+	}
+	return &c
+}
+
+// matchRoute picks the most specific route for url path, first declared wins
+// among equally specific ones. If no route matched, RouteConstraintError tells
+// that some did fit but path parameters did not satisfy their constraints.
+func matchRoute(routes []*Route, path string) (*Route, *URLInfo, error) {
+	var best *Route
+	var bestInfo *URLInfo
+	var err error
+	for _, r := range routes {
+		info, matchErr := r.match(path)
+		if matchErr == nil {
+			if best == nil || r.moreSpecificThan(best) {
+				best, bestInfo = r, info
+			}
+		} else if matchErr != ScriptNotFoundError && err == nil {
+			err = matchErr
+		}
+	}
+	if best != nil {
+		return best, bestInfo, nil
+	}
+	return nil, nil, err
+}
+
+func splitPath(path string) []string {
+	path = strings.Trim(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
@@ -0,0 +1,118 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"testing"
+)
+
+func TestMatchRoute(t *testing.T) {
+	routes := []*Route{
+		{Path: "/", CommandName: "root"},
+		{Path: "/chat", CommandName: "chat"},
+		{Path: "/chat/", CommandName: "chatprefix"},
+		{Path: "/rooms/*/log", CommandName: "roomlog"},
+		{Path: "/rooms/*/", CommandName: "room"},
+		{Path: "/rooms/lobby/", CommandName: "lobby"},
+	}
+	cases := []struct {
+		path, command, scriptPath, pathInfo string
+	}{
+		{"/", "root", "/", ""},
+		{"/other/thing", "root", "/", "/other/thing"},
+		{"/chat", "chat", "/chat", ""},
+		{"/chat/", "chat", "/chat", ""},
+		{"/chat/x/y", "chatprefix", "/chat", "/x/y"},
+		{"/chatter", "root", "/", "/chatter"},
+		{"/rooms/42/log", "roomlog", "/rooms/42/log", ""},
+		{"/rooms/42/log/more", "room", "/rooms/42", "/log/more"},
+		{"/rooms/lobby/x", "lobby", "/rooms/lobby", "/x"},
+		{"/rooms", "root", "/", "/rooms"},
+	}
+	for _, c := range cases {
+		route, info, _ := matchRoute(routes, c.path)
+		if route == nil {
+			t.Errorf("%s: no route matched", c.path)
+			continue
+		}
+		if route.CommandName != c.command || info.ScriptPath != c.scriptPath || info.PathInfo != c.pathInfo {
+			t.Errorf("%s: got %s %s %s, want %s %s %s", c.path, route.CommandName, info.ScriptPath, info.PathInfo,
+				c.command, c.scriptPath, c.pathInfo)
+		}
+	}
+
+	if route, _, err := matchRoute(routes[1:], "/elsewhere"); route != nil || err != nil {
+		t.Errorf("/elsewhere should not match, got %s", route.Path)
+	}
+}
+
+func TestRoutePathParams(t *testing.T) {
+	routes := []*Route{
+		{Path: "/rooms/{room}/users/{user:int}"},
+		{Path: "/items/{id:uuid}/"},
+		{Path: "/tags/{tag:[a-z]+}"},
+		{Path: "/rooms/lobby/users/{user}"},
+	}
+	cases := []struct {
+		path   string
+		route  int
+		params []PathParam
+		err    error
+	}{
+		{"/rooms/kitchen/users/42", 0, []PathParam{{"room", "kitchen"}, {"user", "42"}}, nil},
+		{"/rooms/kitchen/users/bob", -1, nil, RouteConstraintError},
+		{"/rooms/lobby/users/bob", 3, []PathParam{{"user", "bob"}}, nil},
+		{"/rooms/lobby/users/42", 3, []PathParam{{"user", "42"}}, nil},
+		{"/items/123e4567-e89b-12d3-a456-426614174000/more", 1, []PathParam{{"id", "123e4567-e89b-12d3-a456-426614174000"}}, nil},
+		{"/items/123/more", -1, nil, RouteConstraintError},
+		{"/tags/go", 2, []PathParam{{"tag", "go"}}, nil},
+		{"/tags/Go1", -1, nil, RouteConstraintError},
+		{"/tags", -1, nil, nil},
+	}
+	for _, c := range cases {
+		route, info, err := matchRoute(routes, c.path)
+		if c.route < 0 {
+			if route != nil || err != c.err {
+				t.Errorf("%s: got route %v and error %v, want error %v", c.path, route, err, c.err)
+			}
+			continue
+		}
+		if route != routes[c.route] {
+			t.Errorf("%s: got route %v, want %s", c.path, route, routes[c.route].Path)
+			continue
+		}
+		if len(info.PathParams) != len(c.params) {
+			t.Errorf("%s: got params %v, want %v", c.path, info.PathParams, c.params)
+			continue
+		}
+		for i, p := range c.params {
+			if info.PathParams[i] != p {
+				t.Errorf("%s: got params %v, want %v", c.path, info.PathParams, c.params)
+			}
+		}
+	}
+
+	for _, bad := range []string{"/x/{1x}", "/x/{a}/{A}", "/x/a{b}", "/x/{a:[}"} {
+		if err := (&Route{Path: bad}).Compile(); err == nil {
+			t.Errorf("%s: pattern should not compile", bad)
+		}
+	}
+}
+
+func TestRouteApply(t *testing.T) {
+	config := &Config{CommandName: "default", Binary: true, Env: []string{"A=1"}, AllowOrigins: []string{"a.com"}}
+	route := &Route{Path: "/x", CommandName: "x", CommandArgs: []string{"-v"}, Env: []string{"B=2"}}
+	c := route.apply(config)
+	if c.CommandName != "x" || len(c.CommandArgs) != 1 || c.Binary || c.AllowOrigins != nil {
+		t.Errorf("route settings were not applied: %+v", c)
+	}
+	if len(c.Env) != 2 || c.Env[0] != "A=1" || c.Env[1] != "B=2" {
+		t.Errorf("route env was not added: %v", c.Env)
+	}
+	if config.CommandName != "default" || len(config.Env) != 1 {
+		t.Errorf("original config was changed: %+v", config)
+	}
+}
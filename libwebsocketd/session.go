@@ -0,0 +1,159 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	closeFrameTimeout = time.Second
+)
+
+// session is a live WebSocket connection and the process it is piped to
+type session struct {
+	bytesIn  int64 // updated atomically, first for 64-bit alignment
+	bytesOut int64
+
+	handler *WebsocketdHandler
+	ws      *websocket.Conn
+	process *ProcessEndpoint
+	log     *LogScope
+	started time.Time
+
+	closeMu     sync.Mutex
+	closeSent   bool // close frame was sent by server
+	closeCode   int
+	closeReason string
+}
+
+var errDraining = errors.New("server is shutting down")
+
+// addSession registers session in the server. It fails when server is shutting
+// down and new sessions are not welcome anymore, or when session with the same
+// id is running already.
+func (h *WebsocketdServer) addSession(s *session) error {
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	if h.draining {
+		return errDraining
+	}
+	if _, dup := h.sessions[s.handler.Id]; dup {
+		return fmt.Errorf("session %s is running already", s.handler.Id)
+	}
+	if h.sessions == nil {
+		h.sessions = make(map[string]*session)
+	}
+	h.sessions[s.handler.Id] = s
+	h.sessionsWg.Add(1)
+	return nil
+}
+
+func (h *WebsocketdServer) removeSession(s *session) {
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	if h.sessions[s.handler.Id] == s {
+		delete(h.sessions, s.handler.Id)
+		h.sessionsWg.Done()
+	}
+}
+
+// findSession returns live session by WebsocketdHandler.Id, nil if there is none.
+func (h *WebsocketdServer) findSession(id string) *session {
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	return h.sessions[id]
+}
+
+// liveSessions returns sessions that are running now, oldest first.
+func (h *WebsocketdServer) liveSessions() []*session {
+	h.sessionsMu.Lock()
+	live := make([]*session, 0, len(h.sessions))
+	for _, s := range h.sessions {
+		live = append(live, s)
+	}
+	h.sessionsMu.Unlock()
+	sort.Slice(live, func(i, j int) bool { return live[i].started.Before(live[j].started) })
+	return live
+}
+
+func (h *WebsocketdServer) isDraining() bool {
+	h.sessionsMu.Lock()
+	defer h.sessionsMu.Unlock()
+	return h.draining
+}
+
+// StopAccepting makes server reject new WebSocket upgrades, sessions that are
+// already running are not affected.
+func (h *WebsocketdServer) StopAccepting() {
+	h.sessionsMu.Lock()
+	h.draining = true
+	h.sessionsMu.Unlock()
+}
+
+// Wait blocks until all sessions are finished, false is returned if timeout
+// passed first. Zero timeout means waiting for as long as it takes.
+func (h *WebsocketdServer) Wait(timeout time.Duration) bool {
+	finished := make(chan struct{})
+	go func() {
+		h.sessionsWg.Wait()
+		close(finished)
+	}()
+
+	if timeout == 0 {
+		<-finished
+		return true
+	}
+	select {
+	case <-finished:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Shutdown stops accepting WebSocket upgrades, sends close frame with given code
+// and reason to every live session and terminates their processes in parallel.
+// It waits for sessions to finish but no longer than timeout, false is returned
+// if some of them are still running.
+func (h *WebsocketdServer) Shutdown(code int, reason string, timeout time.Duration) bool {
+	h.StopAccepting()
+	live := h.liveSessions()
+
+	h.Log.Info("server", "Draining %d active session(s)", len(live))
+	for _, s := range live {
+		go s.close(code, reason)
+	}
+	return h.Wait(timeout)
+}
+
+// close sends close frame to the client and runs termination of the process
+func (s *session) close(code int, reason string) {
+	s.closeMu.Lock()
+	if !s.closeSent {
+		s.closeSent, s.closeCode, s.closeReason = true, code, reason
+	}
+	s.closeMu.Unlock()
+
+	msg := websocket.FormatCloseMessage(code, reason)
+	if err := s.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeFrameTimeout)); err != nil {
+		s.log.Debug("session", "Cannot send close frame: %s", err)
+	}
+	s.process.Terminate()
+}
+
+// closedByServer returns close code and reason if session was closed by server.
+func (s *session) closedByServer() (int, string, bool) {
+	s.closeMu.Lock()
+	defer s.closeMu.Unlock()
+	return s.closeCode, s.closeReason, s.closeSent
+}
@@ -0,0 +1,60 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGenerateIdUnique(t *testing.T) {
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				id := generateId()
+				mu.Lock()
+				if seen[id] {
+					t.Errorf("id %s was generated twice", id)
+				}
+				seen[id] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAddSessionDuplicate(t *testing.T) {
+	h := NewWebsocketdServer(&Config{}, silentLog(), 0)
+	first := &session{handler: &WebsocketdHandler{Id: "1"}}
+	second := &session{handler: &WebsocketdHandler{Id: "1"}}
+
+	if err := h.addSession(first); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.addSession(second); err == nil {
+		t.Error("session with duplicate id was registered")
+	}
+	if h.findSession("1") != first {
+		t.Error("running session was replaced")
+	}
+	h.removeSession(second)
+	h.removeSession(first)
+	if !h.Wait(time.Second) {
+		t.Error("sessions were not counted out")
+	}
+
+	h.StopAccepting()
+	if err := h.addSession(&session{handler: &WebsocketdHandler{Id: "2"}}); err != errDraining {
+		t.Errorf("draining server should reject sessions, got %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+func init() {
+	// Go versions differ in what they know about, make sure front-end essentials are covered
+	for ext, typ := range map[string]string{
+		".js":   "application/javascript",
+		".mjs":  "application/javascript",
+		".json": "application/json",
+		".wasm": "application/wasm",
+		".svg":  "image/svg+xml",
+	} {
+		if mime.TypeByExtension(ext) == "" {
+			mime.AddExtensionType(ext, typ)
+		}
+	}
+}
+
+// staticFileSystem is http.FileSystem that hides directories without index.html
+// unless directory listings are enabled.
+type staticFileSystem struct {
+	http.Dir
+	listing bool
+}
+
+func (fs staticFileSystem) Open(name string) (http.File, error) {
+	f, err := fs.Dir.Open(name)
+	if err != nil || fs.listing {
+		return f, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if fi.IsDir() {
+		index, err := fs.Dir.Open(path.Join(name, "index.html"))
+		if err != nil {
+			f.Close()
+			return nil, os.ErrNotExist
+		}
+		index.Close()
+	}
+	return f, nil
+}
+
+// serveStatic answers request with file from Config.StaticDir. Conditional
+// (If-Modified-Since, If-None-Match) and Range requests are handled by http.ServeContent.
+func (h *WebsocketdServer) serveStatic(w http.ResponseWriter, req *http.Request, config *Config, log *LogScope) {
+	if etag := staticETag(config.StaticDir, req.URL.Path); etag != "" {
+		w.Header().Set("Etag", etag)
+	}
+	log.Access("http", "STATIC")
+	http.FileServer(staticFileSystem{http.Dir(config.StaticDir), config.StaticListing}).ServeHTTP(w, req)
+}
+
+// staticETag builds weak validator out of file size and modification time, it
+// returns empty string for anything that is not a regular file (or dir with index.html)
+func staticETag(dir, urlPath string) string {
+	name := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+urlPath)))
+	fi, err := os.Stat(name)
+	if err == nil && fi.IsDir() {
+		fi, err = os.Stat(filepath.Join(name, "index.html"))
+	}
+	if err != nil || !fi.Mode().IsRegular() {
+		return ""
+	}
+	return fmt.Sprintf(`W/"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+}
@@ -0,0 +1,50 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServeStatic(t *testing.T) {
+	baseDir := testDir(t, map[string]string{"index.html": "<p>hello</p>", "empty/": ""})
+	log := silentLog()
+	server := &WebsocketdServer{Config: &Config{StaticDir: baseDir}, Log: log}
+	static := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		server.serveStatic(w, req, server.Config, log)
+	})
+	get := func(path string, hdrs map[string]string) *httptest.ResponseRecorder {
+		return serve(static, "GET", path, hdrs)
+	}
+
+	res := get("/", nil)
+	if res.Code != http.StatusOK || res.Body.String() != "<p>hello</p>" {
+		t.Errorf("index.html should be served for /, got %d %#v", res.Code, res.Body.String())
+	}
+	etag := res.Header().Get("Etag")
+	if etag == "" {
+		t.Fatal("ETag was not set")
+	}
+	if res = get("/index.html", map[string]string{"If-None-Match": etag}); res.Code != http.StatusMovedPermanently {
+		t.Errorf("/index.html should redirect to /, got %d", res.Code)
+	}
+	if res = get("/", map[string]string{"If-None-Match": etag}); res.Code != http.StatusNotModified {
+		t.Errorf("matching ETag should give 304, got %d", res.Code)
+	}
+	if res = get("/", map[string]string{"Range": "bytes=3-7"}); res.Code != http.StatusPartialContent || res.Body.String() != "hello" {
+		t.Errorf("range request failed, got %d %#v", res.Code, res.Body.String())
+	}
+	if res = get("/empty/", nil); res.Code != http.StatusNotFound {
+		t.Errorf("directory without index.html should be hidden, got %d", res.Code)
+	}
+
+	server.Config.StaticListing = true
+	if res = get("/empty/", nil); res.Code != http.StatusOK {
+		t.Errorf("directory listing should be allowed, got %d", res.Code)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"bufio"
+	"io"
+)
+
+// Values of Config.StderrForward
+const (
+	StderrForwardBinary = "binary" // stderr lines go as binary messages, stdout ones are text
+	StderrForwardPrefix = "prefix" // stderr lines go as regular messages starting with Config.StderrPrefix
+)
+
+const (
+	defaultStderrCategory  = "stderr"
+	defaultStderrLineLimit = 4096
+	minStderrLineLimit     = 16 // smallest buffer bufio allows
+)
+
+// stderrHandling tells what to do with lines process writes to stderr
+type stderrHandling struct {
+	level    LogLevel
+	category string
+	limit    int
+	forward  func(line []byte) // passes lines to the client, if set
+}
+
+func newStderrHandling(config *Config) stderrHandling {
+	sh := stderrHandling{level: config.StderrLevel, category: config.StderrCategory, limit: config.StderrLineLimit}
+	if sh.category == "" {
+		sh.category = defaultStderrCategory
+	}
+	if sh.limit <= 0 {
+		sh.limit = defaultStderrLineLimit
+	}
+	if sh.limit < minStderrLineLimit {
+		sh.limit = minStderrLineLimit
+	}
+	return sh
+}
+
+// read logs every line of r through log, lines longer than limit are cut.
+func (sh stderrHandling) read(r io.Reader, log *LogScope) {
+	bufstderr := bufio.NewReaderSize(r, sh.limit)
+	for {
+		buf, err := bufstderr.ReadSlice('\n')
+		cut := err == bufio.ErrBufferFull
+		if cut {
+			buf = append(make([]byte, 0, len(buf)), buf...) // skipping the rest reuses buffer
+			for err == bufio.ErrBufferFull {
+				_, err = bufstderr.ReadSlice('\n')
+			}
+		}
+		if len(buf) > 0 {
+			sh.line(trimEOL(buf), cut, log)
+		}
+		if err != nil {
+			if err != io.EOF {
+				log.Error("process", "Unexpected error while reading STDERR from process: %s", err)
+			} else {
+				log.Debug("process", "Process STDERR closed")
+			}
+			break
+		}
+	}
+}
+
+func (sh stderrHandling) line(line []byte, cut bool, log *LogScope) {
+	if sh.level != LogNone {
+		if cut {
+			log.Log(sh.level, sh.category, "%s... (line cut at %d bytes)", line, sh.limit)
+		} else {
+			log.Log(sh.level, sh.category, "%s", line)
+		}
+	}
+	if sh.forward != nil {
+		sh.forward(line)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package libwebsocketd
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestStderrHandling(t *testing.T) {
+	log, logged := capturedLog(LogDebug, "child")
+	var forwarded []string
+	sh := newStderrHandling(&Config{StderrLevel: LogInfo, StderrCategory: "child", StderrLineLimit: 16})
+	sh.forward = func(line []byte) { forwarded = append(forwarded, string(line)) }
+
+	sh.read(strings.NewReader("short\r\n0123456789abcdefXYZ\nlast"), log)
+
+	expected := []string{"INFO short", "INFO 0123456789abcdef... (line cut at 16 bytes)", "INFO last"}
+	if fmt.Sprint(*logged) != fmt.Sprint(expected) {
+		t.Errorf("logged %q, expected %q", *logged, expected)
+	}
+	if fmt.Sprint(forwarded) != fmt.Sprint([]string{"short", "0123456789abcdef", "last"}) {
+		t.Errorf("unexpected forwarded lines %q", forwarded)
+	}
+
+	*logged = nil
+	sh.level = LogNone
+	sh.read(strings.NewReader("dropped\n"), log)
+	if len(*logged) != 0 {
+		t.Errorf("lines should not be logged with LogNone: %q", *logged)
+	}
+}
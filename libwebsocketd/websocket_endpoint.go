@@ -8,6 +8,7 @@ package libwebsocketd
 import (
 	"io"
 	"io/ioutil"
+	"sync"
 
 	"github.com/gorilla/websocket"
 )
@@ -21,6 +22,11 @@ type WebSocketEndpoint struct {
 	output chan []byte
 	log    *LogScope
 	mtype  int
+
+	writeMu sync.Mutex // stderr lines could be sent by other goroutine than PipeEndpoints
+
+	readMu  sync.Mutex
+	readErr error // why reading from client stopped
 }
 
 func NewWebSocketEndpoint(ws *websocket.Conn, bin bool, log *LogScope) *WebSocketEndpoint {
@@ -45,7 +51,23 @@ func (we *WebSocketEndpoint) Output() chan []byte {
 }
 
 func (we *WebSocketEndpoint) Send(msg []byte) bool {
-	w, err := we.ws.NextWriter(we.mtype)
+	return we.send(we.mtype, msg)
+}
+
+// sendStderr passes line of process stderr to the client as binary message or
+// as regular one with prefix.
+func (we *WebSocketEndpoint) sendStderr(line []byte, mode, prefix string) bool {
+	if mode == StderrForwardBinary {
+		return we.send(websocket.BinaryMessage, line)
+	}
+	return we.send(we.mtype, append([]byte(prefix), line...))
+}
+
+func (we *WebSocketEndpoint) send(mtype int, msg []byte) bool {
+	we.writeMu.Lock()
+	defer we.writeMu.Unlock()
+
+	w, err := we.ws.NextWriter(mtype)
 	if err == nil {
 		_, err = w.Write(msg)
 	}
@@ -59,6 +81,14 @@ func (we *WebSocketEndpoint) Send(msg []byte) bool {
 	return true
 }
 
+// readError returns error that stopped reading from the client, nil if it's
+// still going.
+func (we *WebSocketEndpoint) readError() error {
+	we.readMu.Lock()
+	defer we.readMu.Unlock()
+	return we.readErr
+}
+
 func (we *WebSocketEndpoint) StartReading() {
 	go we.read_frames()
 }
@@ -68,6 +98,9 @@ func (we *WebSocketEndpoint) read_frames() {
 		mtype, rd, err := we.ws.NextReader()
 		if err != nil {
 			we.log.Debug("websocket", "Cannot receive: %s", err)
+			we.readMu.Lock()
+			we.readErr = err
+			we.readMu.Unlock()
 			break
 		}
 		if mtype != we.mtype {
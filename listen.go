@@ -0,0 +1,233 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	unixAddrPrefix   = "unix:"
+	listenFdsStart   = 3 // first file descriptor passed by systemd socket activation
+	staleSocketProbe = 100 * time.Millisecond
+)
+
+// listener is a socket websocketd accepts connections on
+type listener struct {
+	net.Listener
+	name     string // address as it was given in configuration, used for logging
+	tcp      bool   // TCP sockets could be used in URLs and for --redirport
+	redirect string // for --redirport sockets, port clients are redirected to
+	service  string // metricsKind or adminKind for sockets serving only that
+}
+
+// openListeners opens all sockets configured by --address, --port, --redirport,
+// --metricsaddr and --adminaddr and picks up sockets passed by systemd socket
+// activation (LISTEN_FDS). When previous websocketd process has handed its
+// sockets over, only those are used.
+func openListeners(config *Config) ([]*listener, error) {
+	if config.InheritedFDs > 0 {
+		return inheritListeners(config)
+	}
+
+	listeners := make([]*listener, 0, len(config.Addr)+config.ListenFDs)
+
+	for i := 0; i < config.ListenFDs; i++ {
+		fd := listenFdsStart + i
+		name := fmt.Sprintf("fd:%d", fd)
+		if i < len(config.ListenFDNames) && config.ListenFDNames[i] != "" {
+			name = fmt.Sprintf("fd:%d(%s)", fd, config.ListenFDNames[i])
+		}
+		l, err := fileListener(fd, name)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, l)
+	}
+
+	for _, addr := range config.Addr {
+		var l net.Listener
+		var err error
+		tcp := !strings.HasPrefix(addr, unixAddrPrefix)
+		if tcp {
+			l, err = net.Listen("tcp", addr)
+		} else {
+			l, err = listenUnix(strings.TrimPrefix(addr, unixAddrPrefix), config)
+		}
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, &listener{Listener: l, name: addr, tcp: tcp})
+	}
+
+	if config.RedirPort != 0 {
+		for _, sock := range listeners {
+			if !sock.tcp || sock.redirect != "" {
+				continue
+			}
+			host, port, _ := net.SplitHostPort(sock.name) // TCP addresses always have port
+			rediraddr := net.JoinHostPort(host, strconv.Itoa(config.RedirPort))
+			l, err := net.Listen("tcp", rediraddr)
+			if err != nil {
+				closeListeners(listeners)
+				return nil, err
+			}
+			listeners = append(listeners, &listener{Listener: l, name: rediraddr, tcp: true, redirect: port})
+		}
+	}
+
+	for _, service := range []struct{ addr, kind string }{
+		{config.MetricsAddr, metricsKind},
+		{config.AdminAddr, adminKind},
+	} {
+		if service.addr == "" {
+			continue
+		}
+		l, err := net.Listen("tcp", service.addr)
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		listeners = append(listeners, &listener{Listener: l, name: service.addr, tcp: true, service: service.kind})
+	}
+	return listeners, nil
+}
+
+// inheritListeners picks up sockets handed over by previous websocketd process,
+// they are passed the same way systemd does it.
+func inheritListeners(config *Config) ([]*listener, error) {
+	listeners := make([]*listener, 0, config.InheritedFDs)
+	for i := 0; i < config.InheritedFDs; i++ {
+		l, err := fileListener(listenFdsStart+i, fmt.Sprintf("fd:%d", listenFdsStart+i))
+		if err != nil {
+			closeListeners(listeners)
+			return nil, err
+		}
+		if !l.tcp {
+			l.name = unixAddrPrefix + l.Addr().String()
+		}
+		if i < len(config.InheritedKinds) {
+			kind := config.InheritedKinds[i]
+			if strings.HasPrefix(kind, redirectKind) {
+				l.redirect = strings.TrimPrefix(kind, redirectKind)
+			}
+			if kind == metricsKind || kind == adminKind {
+				l.service = kind
+			}
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
+
+// fileListener turns inherited file descriptor into listener.
+func fileListener(fd int, name string) (*listener, error) {
+	f := os.NewFile(uintptr(fd), name)
+	l, err := net.FileListener(f)
+	f.Close() // FileListener works with its own copy of descriptor
+	if err != nil {
+		return nil, fmt.Errorf("inherited socket %s is not usable: %s", name, err)
+	}
+	_, tcp := l.Addr().(*net.TCPAddr)
+	if tcp {
+		name = l.Addr().String()
+	}
+	return &listener{Listener: l, name: name, tcp: tcp}, nil
+}
+
+func closeListeners(listeners []*listener) {
+	for _, l := range listeners {
+		l.Close()
+	}
+}
+
+// listenUnix creates unix domain socket at path applying --unixmode and --unixowner to it.
+func listenUnix(path string, config *Config) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		// Socket file left by previous run is removed, unless somebody still listens on it
+		if conn, err := net.DialTimeout("unix", path, staleSocketProbe); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix socket %s is already in use", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	// socket is created with --unixmode right away, so it's not reachable with
+	// looser permissions before chmod (umask is process wide, but listeners
+	// are opened before anything else runs)
+	var l net.Listener
+	var err error
+	if config.UnixMode != 0 {
+		old := umask(int(0777 &^ config.UnixMode.Perm()))
+		l, err = net.Listen("unix", path)
+		umask(old)
+	} else {
+		l, err = net.Listen("unix", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if config.UnixMode != 0 {
+		if err := os.Chmod(path, config.UnixMode); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	if config.UnixUID >= 0 || config.UnixGID >= 0 {
+		if err := os.Chown(path, config.UnixUID, config.UnixGID); err != nil {
+			l.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// parseOwner converts --unixowner value "user[:group]" to numeric ids, -1 means unchanged.
+func parseOwner(s string) (uid, gid int, err error) {
+	uid, gid = -1, -1
+	if s == "" {
+		return uid, gid, nil
+	}
+
+	userName, groupName := s, ""
+	if p := strings.IndexByte(s, ':'); p >= 0 {
+		userName, groupName = s[:p], s[p+1:]
+	}
+
+	if userName != "" {
+		if uid, err = strconv.Atoi(userName); err != nil {
+			u, err := user.Lookup(userName)
+			if err != nil {
+				return -1, -1, err
+			}
+			if uid, err = strconv.Atoi(u.Uid); err != nil {
+				return -1, -1, fmt.Errorf("user %s has non-numeric uid %s", userName, u.Uid)
+			}
+		}
+	}
+	if groupName != "" {
+		if gid, err = strconv.Atoi(groupName); err != nil {
+			g, err := user.LookupGroup(groupName)
+			if err != nil {
+				return -1, -1, err
+			}
+			if gid, err = strconv.Atoi(g.Gid); err != nil {
+				return -1, -1, fmt.Errorf("group %s has non-numeric gid %s", groupName, g.Gid)
+			}
+		}
+	}
+	return uid, gid, nil
+}
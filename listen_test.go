@@ -0,0 +1,158 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+)
+
+func TestOpenListenersUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "websocketd-listen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "ws.sock")
+
+	// socket file left by a crashed run
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	config := &Config{Addr: []string{unixAddrPrefix + path, "127.0.0.1:0"}, UnixMode: 0600, UnixUID: -1, UnixGID: -1}
+	old := umask(0)
+	listeners, err := openListeners(config)
+	umask(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closeListeners(listeners)
+
+	if len(listeners) != 2 || listeners[0].tcp || !listeners[1].tcp || listeners[0].name != unixAddrPrefix+path {
+		t.Fatalf("unexpected listeners %+v %+v", listeners[0], listeners[1])
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("socket should have mode 0600, got %o", fi.Mode().Perm())
+	}
+	if mask := umask(old); mask != old {
+		t.Errorf("umask was not restored, got %o", mask)
+	}
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if l, err := openListeners(&Config{Addr: []string{unixAddrPrefix + path}, UnixUID: -1, UnixGID: -1}); err == nil {
+		closeListeners(l)
+		t.Error("socket in use was taken over")
+	}
+}
+
+func TestFileListener(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	f, err := l.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fd, err := syscall.Dup(int(f.Fd()))
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	inherited, err := fileListener(fd, "fd:x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer inherited.Close()
+	if !inherited.tcp || inherited.name != l.Addr().String() {
+		t.Errorf("inherited TCP socket should be named by its address, got %+v", inherited)
+	}
+}
+
+func TestListenFdsConfig(t *testing.T) {
+	environ := []string{"PATH=/bin:/usr/bin", "LISTEN_PID=" + strconv.Itoa(os.Getpid()), "LISTEN_FDS=2", "LISTEN_FDNAMES=web:admin"}
+	config, err := parseConfig([]string{"cat"}, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.ListenFDs != 2 || len(config.ListenFDNames) != 2 || config.ListenFDNames[1] != "admin" || len(config.Addr) != 0 {
+		t.Errorf("socket activation was not picked up: fds %d, names %q, addr %q", config.ListenFDs, config.ListenFDNames, config.Addr)
+	}
+
+	environ[1] = "LISTEN_PID=1"
+	if config, err = parseConfig([]string{"cat"}, environ); err != nil || config.ListenFDs != 0 {
+		t.Errorf("sockets meant for other process should be ignored, got %d (%v)", config.ListenFDs, err)
+	}
+}
+
+func TestParseOwner(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	uid, _ := strconv.Atoi(current.Uid)
+	gid, _ := strconv.Atoi(current.Gid)
+
+	var tests = []struct {
+		owner    string
+		uid, gid int
+		ok       bool
+	}{
+		{"", -1, -1, true},
+		{"1000", 1000, -1, true},
+		{"1000:50", 1000, 50, true},
+		{":50", -1, 50, true},
+		{current.Username, uid, -1, true},
+		{current.Username + ":" + current.Gid, uid, gid, true},
+		{"no-such-user-websocketd", -1, -1, false},
+		{"1000:no-such-group-websocketd", -1, -1, false},
+	}
+	for _, tt := range tests {
+		uid, gid, err := parseOwner(tt.owner)
+		if (err == nil) != tt.ok || uid != tt.uid || gid != tt.gid {
+			t.Errorf("%q: got %d:%d (%v), expected %d:%d", tt.owner, uid, gid, err, tt.uid, tt.gid)
+		}
+	}
+}
+
+func TestUnixModeFlag(t *testing.T) {
+	environ := []string{"PATH=/bin:/usr/bin"}
+	config, err := parseConfig([]string{"--unixmode=0660", "cat"}, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.UnixMode != 0660 {
+		t.Errorf("got mode %o", config.UnixMode)
+	}
+	for _, bad := range []string{"999", "1777", "rw", "-1"} {
+		if _, err := parseConfig([]string{"--unixmode=" + bad, "cat"}, environ); err == nil {
+			t.Errorf("--unixmode=%s should be rejected", bad)
+		}
+	}
+}
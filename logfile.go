@@ -0,0 +1,231 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedSuffix is appended to --logfile name when it's rotated, sequence
+// number is added if that name is already taken.
+const rotatedSuffix = ".20060102-150405"
+
+// logFile is --logfile destination. It's not safe for concurrent use, writes
+// are serialized by LogScope.Mutex and so should Reopen calls be.
+type logFile struct {
+	path     string
+	maxSize  int64         // rotate once file would grow past it, 0 disables
+	maxAge   time.Duration // rotate once file was written for that long, 0 disables
+	keep     int           // number of rotated files to keep, 0 keeps all
+	compress bool          // gzip rotated files
+
+	f      *os.File
+	size   int64
+	opened time.Time // age of the file is counted from it
+
+	lastStamp string // rotatedSuffix of the last rotated file
+	lastSeq   int    // and its sequence number
+
+	cleanup sync.Mutex // compression and removal of old files happen in background
+}
+
+func openLogFile(config *Config) (*logFile, error) {
+	lf := &logFile{
+		path:     config.LogFile,
+		maxSize:  config.LogMaxSize,
+		maxAge:   config.LogMaxAge,
+		keep:     config.LogMaxFiles,
+		compress: config.LogCompress,
+	}
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+	return lf, nil
+}
+
+func (lf *logFile) open() error {
+	f, err := os.OpenFile(lf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	// age of a file that was written before (by previous run) is counted from
+	// its last change, so restarts do not postpone rotation forever
+	lf.f, lf.size, lf.opened = f, fi.Size(), time.Now()
+	if fi.Size() > 0 && fi.ModTime().Before(lf.opened) {
+		lf.opened = fi.ModTime()
+	}
+	return nil
+}
+
+// Reopen closes the file and opens it by name again, it's what external log
+// rotation expects after it moved the file away.
+func (lf *logFile) Reopen() error {
+	lf.f.Close()
+	return lf.open()
+}
+
+func (lf *logFile) Write(p []byte) (int, error) {
+	if lf.size > 0 && (lf.maxSize > 0 && lf.size+int64(len(p)) > lf.maxSize || lf.maxAge > 0 && time.Since(lf.opened) >= lf.maxAge) {
+		if err := lf.rotate(); err != nil {
+			// keep writing to the old file, there is nowhere to report it anyway
+			fmt.Fprintf(os.Stderr, "Log file %s could not be rotated: %s\n", lf.path, err)
+			lf.opened = time.Now()
+		}
+	}
+	n, err := lf.f.Write(p)
+	lf.size += int64(n)
+	return n, err
+}
+
+func (lf *logFile) rotate() error {
+	// sequence keeps growing within a second, names freed by removal of old
+	// files are not reused as they would sort as the oldest ones
+	stamp, seq := time.Now().Format(rotatedSuffix), 0
+	if stamp == lf.lastStamp {
+		seq = lf.lastSeq + 1
+	}
+	name := rotatedName(lf.path, stamp, seq)
+	for fileExists(name) || fileExists(name+".gz") {
+		seq++
+		name = rotatedName(lf.path, stamp, seq)
+	}
+	if err := os.Rename(lf.path, name); err != nil {
+		return err
+	}
+	lf.f.Close()
+	if err := lf.open(); err != nil {
+		return err
+	}
+	lf.lastStamp, lf.lastSeq = stamp, seq
+	go lf.clean(name)
+	return nil
+}
+
+func rotatedName(path, stamp string, seq int) string {
+	if seq == 0 {
+		return path + stamp
+	}
+	return fmt.Sprintf("%s%s.%d", path, stamp, seq)
+}
+
+// clean compresses just rotated file and removes the oldest ones over the limit.
+func (lf *logFile) clean(rotated string) {
+	lf.cleanup.Lock()
+	defer lf.cleanup.Unlock()
+
+	if lf.compress {
+		if err := gzipFile(rotated); err != nil {
+			fmt.Fprintf(os.Stderr, "Rotated log file %s could not be compressed: %s\n", rotated, err)
+		}
+	}
+	if lf.keep <= 0 {
+		return
+	}
+	old, err := lf.rotatedFiles()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Rotated log files of %s could not be listed: %s\n", lf.path, err)
+		return
+	}
+	for len(old) > lf.keep {
+		if err := os.Remove(old[0]); err != nil {
+			fmt.Fprintf(os.Stderr, "Rotated log file %s could not be removed: %s\n", old[0], err)
+		}
+		old = old[1:]
+	}
+}
+
+// rotatedFiles lists rotated files, oldest first.
+func (lf *logFile) rotatedFiles() ([]string, error) {
+	dir, base := filepath.Split(lf.path)
+	if dir == "" {
+		dir = "."
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	type rotated struct {
+		name  string
+		taken time.Time
+		seq   string
+	}
+	files := make([]rotated, 0, len(names))
+	for _, name := range names {
+		if !strings.HasPrefix(name, base+".") || len(name) < len(base)+len(rotatedSuffix) {
+			continue
+		}
+		stamp := name[len(base) : len(base)+len(rotatedSuffix)]
+		taken, err := time.Parse(rotatedSuffix, stamp)
+		if err != nil {
+			continue
+		}
+		seq := strings.TrimSuffix(name[len(base)+len(rotatedSuffix):], ".gz")
+		files = append(files, rotated{filepath.Join(dir, name), taken, fmt.Sprintf("%8s", seq)})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if !files[i].taken.Equal(files[j].taken) {
+			return files[i].taken.Before(files[j].taken)
+		}
+		return files[i].seq < files[j].seq
+	})
+
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.name
+	}
+	return paths, nil
+}
+
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(path+".gz", os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(out)
+	zw.Name = filepath.Base(path)
+	_, err = io.Copy(zw, in)
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
@@ -0,0 +1,156 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitRotated waits for background cleanup to leave n rotated files, all of
+// them compressed.
+func waitRotated(t *testing.T, lf *logFile, n int) []string {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		files, err := lf.rotatedFiles()
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := len(files) == n
+		for _, f := range files {
+			done = done && strings.HasSuffix(f, ".gz")
+		}
+		if done {
+			return files
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d compressed rotated files, got %q", n, files)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func readGzip(t *testing.T, path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func tempLogDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "websocketd-logfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestLogFileRotateBySize(t *testing.T) {
+	path := filepath.Join(tempLogDir(t), "ws.log")
+	lf, err := openLogFile(&Config{LogFile: path, LogMaxSize: 100, LogMaxFiles: 2, LogCompress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.f.Close()
+
+	chunk := func(i int) string { return strings.Repeat(string(rune('a'+i)), 59) + "\n" }
+	if _, err := lf.Write([]byte(chunk(0))); err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i < 5; i++ {
+		if _, err := lf.Write([]byte(chunk(i))); err != nil {
+			t.Fatal(err)
+		}
+		kept := i
+		if kept > 2 {
+			kept = 2
+		}
+		waitRotated(t, lf, kept)
+	}
+
+	files := waitRotated(t, lf, 2)
+	if got := readGzip(t, files[0]); got != chunk(2) {
+		t.Errorf("older kept archive holds %q, expected %q", got, chunk(2))
+	}
+	if got := readGzip(t, files[1]); got != chunk(3) {
+		t.Errorf("newest archive holds %q, expected %q", got, chunk(3))
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != chunk(4) {
+		t.Errorf("current file holds %q, expected %q", data, chunk(4))
+	}
+}
+
+func TestLogFileAgeOfExistingFile(t *testing.T) {
+	path := filepath.Join(tempLogDir(t), "ws.log")
+	if err := ioutil.WriteFile(path, []byte("old\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(path, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	lf, err := openLogFile(&Config{LogFile: path, LogMaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lf.f.Close()
+	lf.Write([]byte("new\n"))
+	if files, _ := lf.rotatedFiles(); len(files) != 1 {
+		t.Errorf("file written 2 hours ago should be rotated on first write, got %q", files)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "new\n" {
+		t.Errorf("current file holds %q", data)
+	}
+	lf.Write([]byte("more\n"))
+	if files, _ := lf.rotatedFiles(); len(files) != 1 {
+		t.Errorf("fresh file should not be rotated, got %q", files)
+	}
+}
+
+func TestLogFileReopen(t *testing.T) {
+	dir := tempLogDir(t)
+	path := filepath.Join(dir, "ws.log")
+	lf, err := openLogFile(&Config{LogFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { lf.f.Close() }()
+
+	lf.Write([]byte("before\n"))
+	moved := filepath.Join(dir, "ws.log.1")
+	if err := os.Rename(path, moved); err != nil {
+		t.Fatal(err)
+	}
+	lf.Write([]byte("still old\n"))
+	if err := lf.Reopen(); err != nil {
+		t.Fatal(err)
+	}
+	lf.Write([]byte("after\n"))
+
+	if data, _ := ioutil.ReadFile(moved); string(data) != "before\nstill old\n" {
+		t.Errorf("moved file holds %q", data)
+	}
+	if data, _ := ioutil.ReadFile(path); string(data) != "after\n" {
+		t.Errorf("reopened file holds %q", data)
+	}
+}
@@ -0,0 +1,141 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joewalnes/websocketd/libwebsocketd"
+)
+
+// logEntry is a single log message before it's formatted
+type logEntry struct {
+	time      time.Time
+	level     string
+	levelCode libwebsocketd.LogLevel
+	category  string
+	msg       string
+	assoc     []libwebsocketd.AssocPair
+}
+
+// logFormats turn log entry into a line (without trailing newline) for --logformat
+var logFormats = map[string]func(*logEntry) string{
+	"text":   formatText,
+	"json":   formatJSON,
+	"logfmt": formatLogfmt,
+}
+
+// syslogFacilities are names accepted by --log=syslog:FACILITY
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// newLogFunc makes LogFunc passing entries to write, calls are serialized by
+// LogScope.Mutex.
+func newLogFunc(write func(*logEntry)) libwebsocketd.LogFunc {
+	return func(l *libwebsocketd.LogScope, level libwebsocketd.LogLevel, levelName string, category string, msg string, args ...interface{}) {
+		if level < l.MinLevel {
+			return
+		}
+		e := &logEntry{
+			time:      time.Now(),
+			level:     levelName,
+			levelCode: level,
+			category:  category,
+			msg:       fmt.Sprintf(msg, args...),
+			assoc:     l.Associated,
+		}
+
+		l.Mutex.Lock()
+		write(e)
+		l.Mutex.Unlock()
+	}
+}
+
+// lineWriter writes entries to out in given format, one per line.
+func lineWriter(format func(*logEntry) string, out io.Writer) func(*logEntry) {
+	return func(e *logEntry) {
+		io.WriteString(out, format(e)+"\n")
+	}
+}
+
+func formatText(e *logEntry) string {
+	assocDump := ""
+	for index, pair := range e.assoc {
+		if index > 0 {
+			assocDump += " "
+		}
+		assocDump += fmt.Sprintf("%s:'%s'", pair.Key, pair.Value)
+	}
+	return fmt.Sprintf("%s | %-6s | %-10s | %s | %s", e.time.Format(time.RFC1123Z), e.level, e.category, assocDump, e.msg)
+}
+
+// structuredFields lists fields of entry for json and logfmt formats. Associated
+// pairs that clash with standard fields get _ suffix.
+func structuredFields(e *logEntry) []libwebsocketd.AssocPair {
+	fields := []libwebsocketd.AssocPair{
+		{Key: "time", Value: e.time.Format(time.RFC3339Nano)},
+		{Key: "level", Value: strings.ToLower(e.level)},
+		{Key: "category", Value: e.category},
+		{Key: "msg", Value: e.msg},
+	}
+	for _, pair := range e.assoc {
+		switch pair.Key {
+		case "time", "level", "category", "msg":
+			pair.Key += "_"
+		}
+		fields = append(fields, pair)
+	}
+	return fields
+}
+
+func formatJSON(e *logEntry) string {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, f := range structuredFields(e) {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(f.Key)
+		value, _ := json.Marshal(f.Value)
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+func formatLogfmt(e *logEntry) string {
+	var b strings.Builder
+	for i, f := range structuredFields(e) {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(f.Key + "=" + logfmtValue(f.Value))
+	}
+	return b.String()
+}
+
+// logfmtValue quotes value if it's empty or has spaces, quotes or control
+// characters.
+func logfmtValue(v string) string {
+	if v == "" || strings.ContainsAny(v, " =\"\\") || strings.IndexFunc(v, isControl) >= 0 {
+		return strconv.Quote(v)
+	}
+	return v
+}
+
+func isControl(r rune) bool {
+	return r < ' ' || r == 0x7f
+}
@@ -0,0 +1,90 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/joewalnes/websocketd/libwebsocketd"
+)
+
+func formatTestEntry(msg string, assoc ...libwebsocketd.AssocPair) *logEntry {
+	return &logEntry{
+		time:      time.Date(2013, 8, 1, 10, 20, 30, 123456789, time.FixedZone("", 2*3600)),
+		level:     "ACCESS",
+		levelCode: libwebsocketd.LogAccess,
+		category:  "session",
+		msg:       msg,
+		assoc:     assoc,
+	}
+}
+
+var logfmtTests = []struct {
+	msg      string
+	assoc    []libwebsocketd.AssocPair
+	expected string
+}{
+	{"CONNECT", nil, "time=2013-08-01T10:20:30.123456789+02:00 level=access category=session msg=CONNECT"},
+	{"two words", []libwebsocketd.AssocPair{{Key: "id", Value: "1"}, {Key: "empty", Value: ""}},
+		`time=2013-08-01T10:20:30.123456789+02:00 level=access category=session msg="two words" id=1 empty=""`},
+	{`say "hi"`, []libwebsocketd.AssocPair{{Key: "q", Value: "a=b"}, {Key: "path", Value: `c:\x`}},
+		`time=2013-08-01T10:20:30.123456789+02:00 level=access category=session msg="say \"hi\"" q="a=b" path="c:\\x"`},
+	{"line\nbreak", []libwebsocketd.AssocPair{{Key: "tab", Value: "a\tb"}, {Key: "msg", Value: "clash"}},
+		`time=2013-08-01T10:20:30.123456789+02:00 level=access category=session msg="line\nbreak" tab="a\tb" msg_=clash`},
+}
+
+func TestFormatLogfmt(t *testing.T) {
+	for _, tt := range logfmtTests {
+		if got := formatLogfmt(formatTestEntry(tt.msg, tt.assoc...)); got != tt.expected {
+			t.Errorf("got\n%s\nexpected\n%s", got, tt.expected)
+		}
+	}
+}
+
+func TestFormatJSON(t *testing.T) {
+	line := formatJSON(formatTestEntry("say \"hi\"\n", libwebsocketd.AssocPair{Key: "id", Value: "1"},
+		libwebsocketd.AssocPair{Key: "remote", Value: "a=b c"}, libwebsocketd.AssocPair{Key: "level", Value: "x"}))
+	var fields map[string]string
+	if err := json.Unmarshal([]byte(line), &fields); err != nil {
+		t.Fatalf("%s is not valid JSON: %s", line, err)
+	}
+	expected := map[string]string{
+		"time":     "2013-08-01T10:20:30.123456789+02:00",
+		"level":    "access",
+		"category": "session",
+		"msg":      "say \"hi\"\n",
+		"id":       "1",
+		"remote":   "a=b c",
+		"level_":   "x",
+	}
+	if len(fields) != len(expected) {
+		t.Errorf("expected %d fields, got %s", len(expected), line)
+	}
+	for k, v := range expected {
+		if fields[k] != v {
+			t.Errorf("%s: expected %q, got %q", k, v, fields[k])
+		}
+	}
+	if _, err := time.Parse(time.RFC3339Nano, fields["time"]); err != nil {
+		t.Errorf("time is not RFC 3339: %s", err)
+	}
+}
+
+func TestLogLevelNone(t *testing.T) {
+	environ := []string{"PATH=/bin:/usr/bin"}
+	if _, err := parseConfig([]string{"--loglevel=none", "cat"}, environ); err == nil {
+		t.Error("--loglevel=none should be rejected")
+	}
+	config, err := parseConfig([]string{"--stderrlevel=none", "cat"}, environ)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.StderrLevel != libwebsocketd.LogNone {
+		t.Errorf("--stderrlevel=none should be accepted, got %v", config.StderrLevel)
+	}
+}
@@ -0,0 +1,211 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/joewalnes/websocketd/libwebsocketd"
+)
+
+var (
+	syslogSockets   = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+	journaldSockets = []string{"/run/systemd/journal/socket"}
+)
+
+const (
+	logIdentifier = "websocketd"
+	// journald entry is sent as a single datagram, longer ones would not get
+	// through default socket buffers
+	journalMaxSize = 128 * 1024
+	journalCutMark = "... (truncated)"
+)
+
+// unixLogConn is a connection to local log daemon. It's reopened if write
+// fails since daemon could've been restarted, and on every later write until
+// that succeeds.
+type unixLogConn struct {
+	paths    []string
+	networks []string // tried in order for every path
+	network  string   // of conn
+	conn     net.Conn // nil while daemon can't be reached
+}
+
+func dialUnixLog(paths []string, networks ...string) (*unixLogConn, error) {
+	c := &unixLogConn{paths: paths, networks: networks}
+	if err := c.dial(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *unixLogConn) dial() error {
+	var err error
+	for _, path := range c.paths {
+		for _, network := range c.networks {
+			var conn net.Conn
+			if conn, err = net.Dial(network, path); err == nil {
+				c.conn, c.network = conn, network
+				return nil
+			}
+		}
+	}
+	return err
+}
+
+// frame returns msg as it's sent over conn: a datagram keeps message as is,
+// on a stream it's terminated by newline, so newlines inside are replaced.
+func (c *unixLogConn) frame(msg []byte) []byte {
+	if c.network == "unixgram" {
+		return msg
+	}
+	return append(bytes.Replace(msg, []byte("\n"), []byte(" "), -1), '\n')
+}
+
+func (c *unixLogConn) write(msg []byte) {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if c.conn == nil {
+			if err = c.dial(); err != nil {
+				break
+			}
+		}
+		if _, err = c.conn.Write(c.frame(msg)); err == nil {
+			return
+		}
+		c.conn.Close()
+		c.conn = nil
+	}
+	fmt.Fprintf(os.Stderr, "Log message lost: %s\n", err)
+}
+
+// syslogSeverity maps log levels to syslog severities
+func syslogSeverity(level libwebsocketd.LogLevel) int {
+	switch level {
+	case libwebsocketd.LogDebug, libwebsocketd.LogTrace:
+		return 7 // debug
+	case libwebsocketd.LogAccess:
+		return 6 // informational
+	case libwebsocketd.LogInfo:
+		return 5 // notice
+	case libwebsocketd.LogError:
+		return 3 // error
+	default:
+		return 2 // critical
+	}
+}
+
+// openSyslog returns writer sending entries to local syslog. Daemons (rsyslog,
+// syslog-ng, journald) read their local socket as RFC 3164 messages, so that's
+// what is sent, associated pairs are appended to the message as key=value.
+func openSyslog(facility int) (func(*logEntry), error) {
+	c, err := dialUnixLog(syslogSockets, "unixgram", "unix")
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to syslog: %s", err)
+	}
+	pid := os.Getpid()
+
+	return func(e *logEntry) {
+		c.write(formatSyslog(e, facility, pid))
+	}, nil
+}
+
+// formatSyslog renders entry the way syslog(3) sends it to local daemon:
+// "<PRI>TIMESTAMP TAG[PID]: MSG", hostname is added by the daemon.
+func formatSyslog(e *logEntry, facility int, pid int) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "<%d>%s %s[%d]: ", facility*8+syslogSeverity(e.levelCode), e.time.Format(time.Stamp), logIdentifier, pid)
+	if e.category != "" {
+		b.WriteString(e.category + ": ")
+	}
+	b.WriteString(e.msg)
+	for _, pair := range e.assoc {
+		b.WriteString(" " + pair.Key + "=" + logfmtValue(pair.Value))
+	}
+	return b.Bytes()
+}
+
+// openJournald returns writer sending entries to journald using its native
+// protocol, which is datagram only.
+func openJournald() (func(*logEntry), error) {
+	c, err := dialUnixLog(journaldSockets, "unixgram")
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to journald: %s", err)
+	}
+
+	return func(e *logEntry) {
+		msg, truncated := formatJournal(e, journalMaxSize)
+		if truncated {
+			fmt.Fprintf(os.Stderr, "Log message of %d bytes truncated to fit journald datagram\n", len(e.msg))
+		}
+		c.write(msg)
+	}, nil
+}
+
+// formatJournal renders entry as journald fields, associated pairs go as
+// WEBSOCKETD_<KEY> fields. Message is cut so the entry fits maxSize.
+func formatJournal(e *logEntry, maxSize int) ([]byte, bool) {
+	b := journalEntry(e, e.msg)
+	if len(b) <= maxSize {
+		return b, false
+	}
+	cut := len(e.msg) - (len(b) - maxSize) - len(journalCutMark)
+	if cut < 0 {
+		cut = 0
+	}
+	for cut > 0 && !utf8.RuneStart(e.msg[cut]) {
+		cut--
+	}
+	return journalEntry(e, e.msg[:cut]+journalCutMark), true
+}
+
+func journalEntry(e *logEntry, msg string) []byte {
+	var b bytes.Buffer
+	journalField(&b, "MESSAGE", msg)
+	journalField(&b, "PRIORITY", fmt.Sprint(syslogSeverity(e.levelCode)))
+	journalField(&b, "SYSLOG_IDENTIFIER", logIdentifier)
+	journalField(&b, "WEBSOCKETD_CATEGORY", e.category)
+	journalField(&b, "WEBSOCKETD_LEVEL", strings.ToLower(e.level))
+	for _, pair := range e.assoc {
+		journalField(&b, "WEBSOCKETD_"+journalName(pair.Key), pair.Value)
+	}
+	return b.Bytes()
+}
+
+// journalField appends field in journald native format, values with newlines
+// are sent with explicit length.
+func journalField(b *bytes.Buffer, name, value string) {
+	if !strings.ContainsRune(value, '\n') {
+		b.WriteString(name + "=" + value + "\n")
+		return
+	}
+	b.WriteString(name + "\n")
+	binary.Write(b, binary.LittleEndian, uint64(len(value)))
+	b.WriteString(value + "\n")
+}
+
+// journalName makes field name of uppercase letters, digits and underscores.
+func journalName(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, s)
+}
@@ -0,0 +1,165 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/joewalnes/websocketd/libwebsocketd"
+)
+
+func testLogEntry(assoc ...libwebsocketd.AssocPair) *logEntry {
+	return &logEntry{
+		time:      time.Date(2013, 8, 1, 10, 20, 30, 500000000, time.UTC),
+		level:     "ERROR",
+		levelCode: libwebsocketd.LogError,
+		category:  "process",
+		msg:       "exit 1",
+		assoc:     assoc,
+	}
+}
+
+func TestFormatSyslog(t *testing.T) {
+	var tests = []struct {
+		entry    *logEntry
+		expected string
+	}{
+		{testLogEntry(), "<27>Aug  1 10:20:30 websocketd[42]: process: exit 1"},
+		{testLogEntry(libwebsocketd.AssocPair{Key: "id", Value: "1"}, libwebsocketd.AssocPair{Key: "remote", Value: `a "b"`}),
+			`<27>Aug  1 10:20:30 websocketd[42]: process: exit 1 id=1 remote="a \"b\""`},
+		{&logEntry{time: time.Date(2013, 8, 11, 0, 0, 0, 0, time.UTC), levelCode: libwebsocketd.LogDebug, msg: "x"},
+			"<31>Aug 11 00:00:00 websocketd[42]: x"},
+	}
+	for _, tt := range tests {
+		if got := string(formatSyslog(tt.entry, 3, 42)); got != tt.expected {
+			t.Errorf("got\n%s\nexpected\n%s", got, tt.expected)
+		}
+	}
+}
+
+func TestFormatJournal(t *testing.T) {
+	e := testLogEntry(libwebsocketd.AssocPair{Key: "remote-addr", Value: "1.2.3.4"})
+	e.msg = "two\nlines"
+	rest := "PRIORITY=3\n" +
+		"SYSLOG_IDENTIFIER=websocketd\n" +
+		"WEBSOCKETD_CATEGORY=process\n" +
+		"WEBSOCKETD_LEVEL=error\n" +
+		"WEBSOCKETD_REMOTE_ADDR=1.2.3.4\n"
+	expected := "MESSAGE\n\x09\x00\x00\x00\x00\x00\x00\x00two\nlines\n" + rest
+	if got, truncated := formatJournal(e, journalMaxSize); string(got) != expected || truncated {
+		t.Errorf("got\n%q\nexpected\n%q", got, expected)
+	}
+
+	e.msg = strings.Repeat("ü", 100)
+	got, truncated := formatJournal(e, 100+len(rest))
+	if !truncated || len(got) > 100+len(rest) {
+		t.Fatalf("entry of %d bytes should be truncated to %d", len(got), 100+len(rest))
+	}
+	msg := strings.TrimSuffix(strings.TrimPrefix(string(got), "MESSAGE="), "\n"+rest)
+	if !strings.HasSuffix(msg, journalCutMark) || !utf8.ValidString(msg) {
+		t.Errorf("message should be cut at character boundary and marked, got %q", msg)
+	}
+}
+
+func TestUnixLogConn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "websocketd-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+
+	listen := func() *net.UnixConn {
+		l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		l.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return l
+	}
+	receive := func(l *net.UnixConn) string {
+		buf := make([]byte, 1024)
+		n, err := l.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	l := listen()
+	c, err := dialUnixLog([]string{filepath.Join(dir, "missing"), path}, "unixgram", "unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.write([]byte("one\ntwo"))
+	if got := receive(l); got != "one\ntwo" {
+		t.Errorf("datagram should be sent as is, got %q", got)
+	}
+
+	// daemon is gone, message is lost but later writes should redial
+	l.Close()
+	os.Remove(path)
+	c.write([]byte("lost"))
+	if c.conn != nil {
+		t.Fatal("connection should be dropped after failed redial")
+	}
+	c.write([]byte("lost again"))
+
+	l = listen()
+	defer l.Close()
+	c.write([]byte("back"))
+	if got := receive(l); got != "back" {
+		t.Errorf("got %q after daemon restart", got)
+	}
+}
+
+func TestUnixLogConnStream(t *testing.T) {
+	dir, err := ioutil.TempDir("", "websocketd-log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "log")
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	c, err := dialUnixLog([]string{path}, "unixgram", "unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.network != "unix" {
+		t.Fatalf("expected stream connection, got %s", c.network)
+	}
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	c.write([]byte("one\ntwo"))
+	c.write([]byte("three"))
+	r := bufio.NewReader(conn)
+	for _, expected := range []string{"one two\n", "three\n"} {
+		if line, err := r.ReadString('\n'); line != expected {
+			t.Errorf("got %q (%v), expected %q", line, err, expected)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+)
+
+// There are no local syslog and journald sockets on windows.
+
+func openSyslog(facility int) (func(*logEntry), error) {
+	return nil, errors.New("syslog is not supported on windows")
+}
+
+func openJournald() (func(*logEntry), error) {
+	return nil, errors.New("journald is not supported on windows")
+}
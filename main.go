@@ -6,38 +6,67 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/joewalnes/websocketd/libwebsocketd"
 )
 
-func logfunc(l *libwebsocketd.LogScope, level libwebsocketd.LogLevel, levelName string, category string, msg string, args ...interface{}) {
-	if level < l.MinLevel {
-		return
+func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(replayMain(os.Args[2:]))
 	}
-	fullMsg := fmt.Sprintf(msg, args...)
 
-	assocDump := ""
-	for index, pair := range l.Associated {
-		if index > 0 {
-			assocDump += " "
-		}
-		assocDump += fmt.Sprintf("%s:'%s'", pair.Key, pair.Value)
+	environ := os.Environ() // kept for reloads and handoffs, process environment is wiped below
+	config := parseCommandLine()
+	executable, err := os.Executable()
+	if err != nil {
+		executable = os.Args[0]
 	}
 
-	l.Mutex.Lock()
-	fmt.Printf("%s | %-6s | %-10s | %s | %s\n", libwebsocketd.Timestamp(), levelName, category, assocDump, fullMsg)
-	l.Mutex.Unlock()
-}
-
-func main() {
-	config := parseCommandLine()
+	var logOut io.Writer = os.Stdout
+	var logfile *logFile
+	if config.LogFile != "" {
+		if logfile, err = openLogFile(config); err != nil {
+			fmt.Fprintf(os.Stderr, "Can't open log file: %s\n", err)
+			os.Exit(3)
+		}
+		logOut = logfile
+	}
+	writeLog, logErr := lineWriter(logFormats[config.LogFormat], logOut), error(nil)
+	switch config.LogDest {
+	case "syslog":
+		writeLog, logErr = openSyslog(config.LogFacility)
+	case "journald":
+		writeLog, logErr = openJournald()
+	}
+	if logErr != nil {
+		fmt.Fprintf(os.Stderr, "Can't start logging: %s\n", logErr)
+		os.Exit(3)
+	}
+	log := libwebsocketd.RootLogScope(config.LogLevel, newLogFunc(writeLog))
 
-	log := libwebsocketd.RootLogScope(config.LogLevel, logfunc)
+	if config.DevConsole {
+		if config.StaticDir != "" {
+			log.Fatal("server", "Invalid parameters: --devconsole cannot be used with --staticdir. Pick one.")
+			os.Exit(4)
+		}
+		if config.CgiDir != "" {
+			log.Fatal("server", "Invalid parameters: --devconsole cannot be used with --cgidir. Pick one.")
+			os.Exit(4)
+		}
+	}
 
 	if runtime.GOOS != "windows" { // windows relies on env variables to find its libs... e.g. socket stuff
 		os.Clearenv() // it's ok to wipe it clean, we already read env variables from passenv into config
@@ -45,22 +74,296 @@ func main() {
 	handler := libwebsocketd.NewWebsocketdServer(config.Config, log, config.MaxForks)
 	http.Handle("/", handler)
 
-	log.Info("server", "Serving using application   : %s %s", config.CommandName, strings.Join(config.CommandArgs, " "))
+	if config.ConfigFile != "" {
+		log.Info("server", "Using configuration file    : %s", config.ConfigFile)
+	}
+	if config.UsingScriptDir {
+		log.Info("server", "Serving from directory      : %s", config.ScriptDir)
+	} else if config.CommandName != "" {
+		log.Info("server", "Serving using application   : %s %s", config.CommandName, strings.Join(config.CommandArgs, " "))
+	}
+	for _, r := range config.Routes {
+		log.Info("server", "Serving route %-14s: %s %s", r.Path, r.CommandName, strings.Join(r.CommandArgs, " "))
+	}
+	if config.StaticDir != "" {
+		log.Info("server", "Serving static content from : %s", config.StaticDir)
+	}
+	if config.CgiDir != "" {
+		log.Info("server", "Serving CGI scripts from    : %s", config.CgiDir)
+	}
+
+	var tlsConf *tls.Config
+	if config.Ssl {
+		if tlsConf, err = tlsConfig(config); err != nil {
+			log.Fatal("server", "Can't configure TLS: %s", err)
+			os.Exit(3)
+		}
+		if config.ClientCAFile != "" {
+			log.Info("server", "Verifying client certs with : %s", config.ClientCAFile)
+		}
+	}
+
+	// Signals are caught before sockets are opened so early SIGTERM also drains properly
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	reloads := make(chan os.Signal, 1)
+	if len(reloadSignals) > 0 {
+		signal.Notify(reloads, reloadSignals...)
+	}
+	handoffs := make(chan os.Signal, 1)
+	if len(handoffSignals) > 0 {
+		signal.Notify(handoffs, handoffSignals...)
+	}
+	reopens := make(chan os.Signal, 1)
+	if logfile != nil && len(reopenSignals) > 0 {
+		signal.Notify(reopens, reopenSignals...)
+	}
+
+	listeners, err := openListeners(config)
+	if err != nil {
+		log.Fatal("server", "Can't start server: %s", err)
+		os.Exit(3)
+	}
 
 	rejects := make(chan error, 1)
-	for _, addrSingle := range config.Addr {
-		log.Info("server", "Starting WebSocket server   : ws://%s/", addrSingle)
-		// ListenAndServe is blocking function. Let's run it in
+	servers := make([]*http.Server, 0, len(listeners))
+	for _, l := range listeners {
+		addrSingle := l.name
+		if l.redirect != "" {
+			log.Info("server", "Starting redirect server    : http://%s/", addrSingle)
+		} else if l.service == metricsKind {
+			log.Info("server", "Serving metrics on          : http://%s%s", addrSingle, config.MetricsAddrPath)
+		} else if l.service == adminKind {
+			log.Info("server", "Serving admin API on        : http://%s/sessions", addrSingle)
+		} else if !l.tcp {
+			log.Info("server", "Listening on socket         : %s", addrSingle)
+		} else {
+			if config.CommandName != "" || config.UsingScriptDir || len(config.Routes) > 0 {
+				log.Info("server", "Starting WebSocket server   : %s", handler.TellURL("ws", addrSingle, "/"))
+			}
+			if config.DevConsole {
+				log.Info("server", "Developer console enabled   : %s", handler.TellURL("http", addrSingle, "/"))
+			} else if config.StaticDir != "" || config.CgiDir != "" {
+				log.Info("server", "Serving CGI or static files : %s", handler.TellURL("http", addrSingle, "/"))
+			}
+		}
+		// Serve is blocking function. Let's run it in
 		// go routine, reporting result to control channel.
 		// Since it's blocking it'll never return non-error.
 
-		go func(addr string) {
-			rejects <- http.ListenAndServe(addr, nil)
-		}(addrSingle)
+		if l.redirect != "" {
+			srv := &http.Server{Handler: redirectHandler(config, l.redirect)}
+			servers = append(servers, srv)
+			go func(srv *http.Server, l net.Listener) {
+				rejects <- srv.Serve(l)
+			}(srv, l)
+			continue
+		}
+		if l.service != "" {
+			srv := &http.Server{}
+			if l.service == adminKind {
+				srv.Handler = handler.AdminHandler(config.AdminToken)
+			} else {
+				srv.Handler = metricsHandler(handler, config.MetricsAddrPath)
+			}
+			servers = append(servers, srv)
+			go func(srv *http.Server, l net.Listener) {
+				rejects <- srv.Serve(l)
+			}(srv, l)
+			continue
+		}
+
+		srv := &http.Server{TLSConfig: tlsConf}
+		servers = append(servers, srv)
+		go func(srv *http.Server, l net.Listener) {
+			if config.Ssl {
+				rejects <- srv.ServeTLS(l, config.CertFile, config.KeyFile)
+			} else {
+				rejects <- srv.Serve(l)
+			}
+		}(srv, l)
+	}
+	if config.ReadyFD != 0 {
+		log.Info("server", "Took over %d socket(s) from previous process", len(listeners))
+		notifyReady(config.ReadyFD)
+	}
+
+	running := config // replaced on reloads
+	handedOff := false
+	retired := make(chan struct{}) // closed when sessions left after handoff are finished
+	for {
+		select {
+		case err = <-rejects:
+			if err == http.ErrServerClosed {
+				continue // sockets were handed over to new process
+			}
+			log.Fatal("server", "Can't start server: %s", err)
+			os.Exit(3)
+		case sig := <-reloads:
+			if handedOff {
+				log.Info("server", "Received %s, ignored since sockets were handed over", sig)
+				continue
+			}
+			log.Info("server", "Received %s, reloading configuration", sig)
+			running = reload(handler, running, environ, log)
+		case sig := <-reopens:
+			log.Mutex.Lock()
+			err := logfile.Reopen()
+			log.Mutex.Unlock()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Can't reopen log file on %s: %s\n", sig, err)
+			} else {
+				log.Info("server", "Received %s, log file reopened", sig)
+			}
+		case sig := <-handoffs:
+			if handedOff {
+				log.Info("server", "Received %s, ignored since sockets were handed over", sig)
+				continue
+			}
+			log.Info("server", "Received %s, handing sockets over to %s", sig, executable)
+			if err := handoff(executable, environ, listeners); err != nil {
+				log.Error("server", "Handoff failed, keep serving: %s", err)
+				continue
+			}
+			handedOff = true
+			log.Info("server", "Sockets handed over, waiting for active sessions to finish")
+			go func() {
+				retire(handler, servers, listeners)
+				close(retired)
+			}()
+		case <-retired:
+			log.Info("server", "All sessions are finished, bye")
+			return
+		case sig := <-signals:
+			log.Info("server", "Received %s, shutting down (send again to exit immediately)", sig)
+			go func() {
+				<-signals
+				log.Error("server", "Second signal received, exiting without waiting for sessions")
+				os.Exit(1)
+			}()
+			if !shutdown(handler, servers, running) {
+				log.Error("server", "Drain timeout of %s passed, some sessions were abandoned", running.DrainTimeout)
+				os.Exit(1)
+			}
+			log.Info("server", "All sessions are finished, bye")
+			return
+		}
 	}
-	err := <-rejects
+}
+
+// reload re-reads configuration and applies everything that doesn't need sockets
+// to be reopened, old configuration is kept if new one is not valid.
+func reload(handler *libwebsocketd.WebsocketdServer, config *Config, environ []string, log *libwebsocketd.LogScope) *Config {
+	newConfig, err := reloadConfig(config, os.Args[1:], environ)
 	if err != nil {
-		log.Fatal("server", "Can't start server: %s", err)
-		os.Exit(3)
+		log.Error("server", "Configuration is not reloaded: %s", err)
+		return config
+	}
+	handler.Reload(newConfig.Config, newConfig.MaxForks)
+	log.Info("server", "Configuration reloaded")
+	return newConfig
+}
+
+// reloadConfig reads configuration again, settings that only take effect on
+// start are kept from running config.
+func reloadConfig(config *Config, arguments []string, environ []string) (*Config, error) {
+	newConfig, err := parseConfig(arguments, environ)
+	if err != nil {
+		return nil, err
+	}
+	if newConfig.Ssl != config.Ssl {
+		return nil, errors.New("--ssl could not be changed without handoff")
+	}
+	if newConfig.DevConsole && (newConfig.StaticDir != "" || newConfig.CgiDir != "") {
+		return nil, errors.New("--devconsole cannot be used with --staticdir or --cgidir")
+	}
+
+	// Sockets and TLS setup stay as they are until handoff
+	newConfig.Addr, newConfig.RedirPort = config.Addr, config.RedirPort
+	newConfig.MetricsAddr, newConfig.MetricsAddrPath = config.MetricsAddr, config.MetricsAddrPath
+	newConfig.AdminAddr, newConfig.AdminToken = config.AdminAddr, config.AdminToken
+	newConfig.CertFile, newConfig.KeyFile = config.CertFile, config.KeyFile
+	newConfig.ReadyFD = 0
+	return newConfig, nil
+}
+
+// retire stops accepting connections on sockets handed over to new process and
+// waits for as long as active sessions last.
+func retire(handler *libwebsocketd.WebsocketdServer, servers []*http.Server, listeners []*listener) {
+	handler.StopAccepting()
+	for _, l := range listeners {
+		if ul, ok := l.Listener.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false) // new process listens on the same path
+		}
+	}
+	for _, srv := range servers {
+		go srv.Shutdown(context.Background())
+	}
+	handler.Wait(0)
+}
+
+// shutdown closes listeners and drains active sessions within --drain-timeout
+func shutdown(handler *libwebsocketd.WebsocketdServer, servers []*http.Server, config *Config) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), config.DrainTimeout)
+	defer cancel()
+
+	// Listeners are closed right away, Shutdown waits only for plain HTTP requests
+	// (upgraded connections are hijacked and not tracked by http.Server)
+	stopped := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		for _, srv := range servers {
+			wg.Add(1)
+			go func(srv *http.Server) {
+				defer wg.Done()
+				srv.Shutdown(ctx)
+			}(srv)
+		}
+		wg.Wait()
+		close(stopped)
+	}()
+
+	drained := handler.Shutdown(config.DrainCloseCode, config.DrainCloseReason, config.DrainTimeout)
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		return false
 	}
+	return drained
+}
+
+// metricsHandler serves --metricsaddr listener, nothing but metrics is there.
+func metricsHandler(handler *libwebsocketd.WebsocketdServer, path string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != path {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeMetrics(w, r)
+	})
+}
+
+// redirectHandler answers every request with permanent redirect to the same host,
+// path and query but served on canonical port (and schema) of websocketd.
+func redirectHandler(config *Config, port string) http.Handler {
+	scheme, defaultPort := "http", "80"
+	if config.Ssl {
+		scheme, defaultPort = "https", "443"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host // there was no port in Host header
+		}
+		host = strings.Trim(host, "[]")
+		if host == "" {
+			host = libwebsocketd.CanonicalHostname()
+		}
+		if port != defaultPort {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6 literal without port
+		}
+		http.Redirect(w, r, scheme+"://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
 }
@@ -0,0 +1,94 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/joewalnes/websocketd/libwebsocketd"
+)
+
+var redirectTests = []struct {
+	ssl      bool
+	port     string
+	host     string
+	location string
+}{
+	{true, "443", "example.com:8080", "https://example.com/chat?room=1"},
+	{true, "443", "example.com", "https://example.com/chat?room=1"},
+	{true, "8443", "example.com:8080", "https://example.com:8443/chat?room=1"},
+	{true, "8443", "example.com", "https://example.com:8443/chat?room=1"},
+	{true, "443", "[::1]:8080", "https://[::1]/chat?room=1"},
+	{true, "443", "[::1]", "https://[::1]/chat?room=1"},
+	{true, "8443", "[::1]:8080", "https://[::1]:8443/chat?room=1"},
+	{true, "8443", "[::1]", "https://[::1]:8443/chat?room=1"},
+	{false, "80", "example.com:8080", "http://example.com/chat?room=1"},
+	{false, "8080", "example.com", "http://example.com:8080/chat?room=1"},
+	{true, "443", "", "https://" + libwebsocketd.CanonicalHostname() + "/chat?room=1"},
+}
+
+func TestRedirectHandler(t *testing.T) {
+	for _, tt := range redirectTests {
+		req := httptest.NewRequest("GET", "/chat?room=1", nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		redirectHandler(&Config{Config: &libwebsocketd.Config{Ssl: tt.ssl}}, tt.port).ServeHTTP(rec, req)
+		if rec.Code != 301 || rec.Header().Get("Location") != tt.location {
+			t.Errorf("ssl %v, port %s, host %q: got %d %s, expected %s",
+				tt.ssl, tt.port, tt.host, rec.Code, rec.Header().Get("Location"), tt.location)
+		}
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "websocketd-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "websocketd.yaml")
+	args := []string{"--config=" + path}
+	write := func(data string) {
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("port: 8080\nmaxforks: 2\nadminaddr: 127.0.0.1:9000\nmetricsaddr: 127.0.0.1:9100\ncommand: cat\n")
+	config, err := parseConfig(args, []string{"PATH=/bin:/usr/bin", adminTokenEnv + "=old", inheritFdsEnv + "=1", handoffReadyEnv + "=4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	write("port: 9090\nmaxforks: 5\nadminaddr: 127.0.0.1:9001\nmetricsaddr: 127.0.0.1:9101\nheader: [\"X-New: 1\"]\ncommand: [cat, -u]\n")
+	reloaded, err := reloadConfig(config, args, []string{"PATH=/bin:/usr/bin", adminTokenEnv + "=new"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// changed right away
+	if reloaded.MaxForks != 5 || len(reloaded.Headers) != 1 || len(reloaded.CommandArgs) != 1 {
+		t.Errorf("settings were not reloaded: maxforks %d, headers %q, args %q", reloaded.MaxForks, reloaded.Headers, reloaded.CommandArgs)
+	}
+	// kept until handoff
+	if len(reloaded.Addr) != 1 || reloaded.Addr[0] != ":8080" || reloaded.AdminAddr != "127.0.0.1:9000" ||
+		reloaded.MetricsAddr != "127.0.0.1:9100" || reloaded.AdminToken != "old" || reloaded.ReadyFD != 0 {
+		t.Errorf("sockets should be kept: addr %q, admin %s (%s), metrics %s, ready fd %d",
+			reloaded.Addr, reloaded.AdminAddr, reloaded.AdminToken, reloaded.MetricsAddr, reloaded.ReadyFD)
+	}
+
+	config.Ssl = true
+	if _, err := reloadConfig(config, args, []string{"PATH=/bin:/usr/bin", adminTokenEnv + "=new"}); err == nil {
+		t.Error("--ssl change was accepted")
+	}
+	write("bogus: 1\n")
+	if _, err := reloadConfig(reloaded, args, []string{"PATH=/bin:/usr/bin"}); err == nil {
+		t.Error("broken configuration was accepted")
+	}
+}
@@ -0,0 +1,167 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/joewalnes/websocketd/libwebsocketd"
+)
+
+// maxDiffCells limits memory used to find common messages of differing
+// outputs, bigger differences are shown as whole.
+const maxDiffCells = 16 * 1024 * 1024
+
+// replayMain runs "replay" subcommand and returns exit code: 0 if process
+// answered as recorded, 1 if it did not and 2 if replay failed.
+func replayMain(arguments []string) int {
+	flags := flag.NewFlagSet(HelpProcessName()+" replay", flag.ContinueOnError)
+	flags.Usage = func() { fmt.Fprintf(os.Stderr, "\n%s\n", get_help_message(replayHelp)) }
+	fastFlag := flags.Bool("fast", false, "Send messages as fast as possible instead of keeping original timing")
+	timeoutFlag := flags.Duration("timeout", 5*time.Second, "Time to wait for output once all messages were sent")
+	logLevelFlag := flags.String("loglevel", "error", "Log level of replayed process messages (stderr and such)")
+	if err := flags.Parse(arguments); err != nil {
+		if err == flag.ErrHelp {
+			return 0
+		}
+		return 2
+	}
+	if flags.NArg() < 1 {
+		fmt.Fprintf(os.Stderr, "Transcript file is missing.\n")
+		flags.Usage()
+		return 2
+	}
+	logLevel := libwebsocketd.LevelFromString(*logLevelFlag)
+	if logLevel == libwebsocketd.LogUnknown || logLevel == libwebsocketd.LogNone {
+		fmt.Fprintf(os.Stderr, "Incorrect loglevel flag '%s'. Use --help to see allowed values.\n", *logLevelFlag)
+		return 2
+	}
+
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can't open transcript: %s\n", err)
+		return 2
+	}
+	entries, err := libwebsocketd.ReadRecord(f)
+	f.Close()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can't read transcript %s: %s\n", flags.Arg(0), err)
+		return 2
+	}
+
+	config := &libwebsocketd.ReplayConfig{Fast: *fastFlag, Timeout: *timeoutFlag}
+	if flags.NArg() > 1 {
+		config.CommandName, config.CommandArgs = flags.Arg(1), flags.Args()[2:]
+	}
+	log := libwebsocketd.RootLogScope(logLevel, newLogFunc(lineWriter(logFormats["text"], os.Stderr)))
+	res, err := libwebsocketd.Replay(entries, config, log)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Can't replay %s: %s\n", flags.Arg(0), err)
+		return 2
+	}
+
+	same := printDiff(os.Stdout, res.Expected, res.Output, entries[0].Binary)
+	if res.ExpectedExitCode != nil && *res.ExpectedExitCode != res.ExitCode {
+		fmt.Printf("exit code %d, expected %d (termination stage %s)\n", res.ExitCode, *res.ExpectedExitCode, res.Stage)
+		same = false
+	}
+	if res.TimedOut {
+		fmt.Printf("gave up waiting for output after %s\n", *timeoutFlag)
+	}
+	if res.Truncated {
+		fmt.Printf("transcript was truncated, only its recorded part was compared\n")
+	}
+	if !same {
+		return 1
+	}
+	fmt.Printf("%d messages as recorded\n", len(res.Output))
+	return 0
+}
+
+// printDiff writes lines of differing messages, "-" for expected and "+" for
+// the ones got instead, with unchanged messages around as context. It returns
+// true if there was nothing to print.
+func printDiff(w io.Writer, expected, got [][]byte, binary bool) bool {
+	// cut common prefix and suffix, usually that leaves little to compare
+	pre := 0
+	for pre < len(expected) && pre < len(got) && bytes.Equal(expected[pre], got[pre]) {
+		pre++
+	}
+	suf := 0
+	for suf < len(expected)-pre && suf < len(got)-pre && bytes.Equal(expected[len(expected)-1-suf], got[len(got)-1-suf]) {
+		suf++
+	}
+	if pre == len(expected) && pre == len(got) {
+		return true
+	}
+	a, b := expected[pre:len(expected)-suf], got[pre:len(got)-suf]
+
+	show := func(mark string, msg []byte) {
+		if binary || !utf8.Valid(msg) {
+			fmt.Fprintf(w, "%s %s\n", mark, strconv.Quote(string(msg)))
+		} else {
+			fmt.Fprintf(w, "%s %s\n", mark, msg)
+		}
+	}
+	if pre > 0 {
+		fmt.Fprintf(w, "@@ message %d\n", pre)
+		show(" ", expected[pre-1])
+	} else {
+		fmt.Fprintf(w, "@@ message 1\n")
+	}
+
+	// longest common subsequence of what's left
+	if len(a)*len(b) > maxDiffCells {
+		for _, msg := range a {
+			show("-", msg)
+		}
+		for _, msg := range b {
+			show("+", msg)
+		}
+	} else {
+		lcs := make([][]int, len(a)+1)
+		for i := range lcs {
+			lcs[i] = make([]int, len(b)+1)
+		}
+		for i := len(a) - 1; i >= 0; i-- {
+			for j := len(b) - 1; j >= 0; j-- {
+				if bytes.Equal(a[i], b[j]) {
+					lcs[i][j] = lcs[i+1][j+1] + 1
+				} else if lcs[i+1][j] >= lcs[i][j+1] {
+					lcs[i][j] = lcs[i+1][j]
+				} else {
+					lcs[i][j] = lcs[i][j+1]
+				}
+			}
+		}
+		i, j := 0, 0
+		for i < len(a) || j < len(b) {
+			switch {
+			case i < len(a) && j < len(b) && bytes.Equal(a[i], b[j]):
+				show(" ", a[i])
+				i, j = i+1, j+1
+			case j == len(b) || i < len(a) && lcs[i+1][j] >= lcs[i][j+1]:
+				show("-", a[i])
+				i++
+			default:
+				show("+", b[j])
+				j++
+			}
+		}
+	}
+
+	if suf > 0 {
+		show(" ", expected[len(expected)-suf])
+	}
+	return false
+}
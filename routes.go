@@ -0,0 +1,196 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/joewalnes/websocketd/libwebsocketd"
+)
+
+// parseRoute reads --route value "/path=command args". Settings that are not
+// given in it are the same as for COMMAND.
+func parseRoute(s string, defaults *libwebsocketd.Config, path string) (*libwebsocketd.Route, error) {
+	p := strings.IndexByte(s, '=')
+	command := strings.Fields(s[p+1:])
+	if p <= 0 || len(command) == 0 {
+		return nil, fmt.Errorf("Incorrect --route '%s', it should look like /path=command args.", s)
+	}
+	return newRoute(s[:p], command, defaults, path)
+}
+
+func newRoute(urlPath string, command []string, defaults *libwebsocketd.Config, path string) (*libwebsocketd.Route, error) {
+	if !strings.HasPrefix(urlPath, "/") {
+		return nil, fmt.Errorf("Route path '%s' should start with /.", urlPath)
+	}
+	name, err := lookPath(command[0], path)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to locate command '%s' of route %s in OS path.", command[0], urlPath)
+	}
+	route := &libwebsocketd.Route{
+		Path:         urlPath,
+		CommandName:  name,
+		CommandArgs:  command[1:],
+		Binary:       defaults.Binary,
+		CloseMs:      defaults.CloseMs,
+		AllowOrigins: defaults.AllowOrigins,
+		SameOrigin:   defaults.SameOrigin,
+	}
+	if err := route.Compile(); err != nil {
+		return nil, fmt.Errorf("Incorrect route: %s.", err)
+	}
+	return route, nil
+}
+
+// routesFromConfigFile reads "routes" list of configuration file. Each item is
+// a table with "path" and "command" keys, and optionally "binary", "maxforks",
+// "closems", "origin", "sameorigin" and "env" ones. References in env values are
+// expanded against global variables and websocketd own environment.
+func routesFromConfigFile(file string, v *configValue, defaults *libwebsocketd.Config, environ []string) ([]*libwebsocketd.Route, error) {
+	if v.kind != listValue {
+		return nil, fmt.Errorf("%s:%d: routes should be a list", file, v.line)
+	}
+
+	problems := make([]string, 0)
+	report := func(line int, format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf("%s:%d: %s", file, line, fmt.Sprintf(format, args...)))
+	}
+
+	path := getenv(environ, "PATH")
+	globalEnv := append(append(make([]string, 0, len(environ)+len(defaults.Env)), environ...), defaults.Env...)
+
+	routes := make([]*libwebsocketd.Route, 0, len(v.list))
+	for _, item := range v.list {
+		if item.kind != tableValue {
+			report(item.line, "route should be a table with path and command")
+			continue
+		}
+		t := item.table
+		urlPath, command := "", []string(nil)
+		if p, ok := t.values["path"]; ok && p.kind == scalarValue {
+			urlPath = p.scalar
+		}
+		if c, ok := t.values["command"]; ok {
+			command, _ = c.strings()
+			if len(command) == 1 {
+				command = strings.Fields(command[0])
+			}
+		}
+		if urlPath == "" || len(command) == 0 {
+			report(item.line, "route should have path and command")
+			continue
+		}
+		route, err := newRoute(urlPath, command, defaults, path)
+		if err != nil {
+			report(item.line, "%s", err)
+			continue
+		}
+
+		for _, key := range t.keys {
+			val := t.values[key]
+			var err error
+			switch key {
+			case "path", "command":
+			case "binary":
+				route.Binary, err = strconv.ParseBool(val.scalar)
+			case "sameorigin":
+				route.SameOrigin, err = strconv.ParseBool(val.scalar)
+			case "maxforks":
+				route.MaxForks, err = strconv.Atoi(val.scalar)
+			case "closems":
+				var ms uint64
+				ms, err = strconv.ParseUint(val.scalar, 10, 32)
+				route.CloseMs = uint(ms)
+			case "origin":
+				route.AllowOrigins, err = originList(val)
+			case "env":
+				route.Env, err = envList(val, newEnvBuilder(globalEnv))
+			default:
+				report(val.line, "unknown route key '%s'", key)
+				continue
+			}
+			if ce, ok := err.(*configError); ok {
+				report(ce.line, "%s: %s", key, ce.msg)
+			} else if err != nil {
+				report(val.line, "invalid value %q for %s: %s", val.scalar, key, err)
+			}
+		}
+		routes = append(routes, route)
+	}
+
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "\n"))
+	}
+	return routes, nil
+}
+
+// originList accepts list of origins as well as comma separated string.
+func originList(v *configValue) ([]string, error) {
+	values, err := v.strings()
+	if err != nil {
+		return nil, err
+	}
+	origins := make([]string, 0, len(values))
+	for _, s := range values {
+		for _, o := range strings.Split(s, ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				origins = append(origins, o)
+			}
+		}
+	}
+	if len(origins) == 0 {
+		return nil, nil
+	}
+	return origins, nil
+}
+
+// envList accepts table of variables as well as list of "KEY=value" strings.
+func envList(v *configValue, env *envBuilder) ([]string, error) {
+	var list []string
+	if v.kind == tableValue {
+		for _, key := range v.table.keys {
+			if val := v.table.values[key]; val.kind != scalarValue {
+				return nil, &configError{val.line, "variable value should be plain value"}
+			} else {
+				list = append(list, key+"="+val.scalar)
+			}
+		}
+	} else {
+		var err error
+		if list, err = v.strings(); err != nil {
+			return nil, err
+		}
+	}
+	for _, kv := range list {
+		if err := env.assign(kv); err != nil {
+			return nil, &configError{v.line, err.Error()}
+		}
+	}
+	return env.list(), nil
+}
+
+// dumpRoutes prints routes the way routesFromConfigFile reads them.
+func dumpRoutes(routes []*libwebsocketd.Route) string {
+	if len(routes) == 0 {
+		return "routes: []\n"
+	}
+	var b strings.Builder
+	b.WriteString("routes:\n")
+	for _, r := range routes {
+		fmt.Fprintf(&b, "  - path: %s\n", yamlScalar(r.Path))
+		b.WriteString(yamlList("    ", "command", append([]string{r.CommandName}, r.CommandArgs...)))
+		fmt.Fprintf(&b, "    binary: %t\n", r.Binary)
+		fmt.Fprintf(&b, "    maxforks: %d\n", r.MaxForks)
+		fmt.Fprintf(&b, "    closems: %d\n", r.CloseMs)
+		b.WriteString(yamlList("    ", "origin", r.AllowOrigins))
+		fmt.Fprintf(&b, "    sameorigin: %t\n", r.SameOrigin)
+		b.WriteString(yamlList("    ", "env", r.Env))
+	}
+	return b.String()
+}
@@ -0,0 +1,20 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+var (
+	reloadSignals  = []os.Signal{syscall.SIGHUP}  // re-read configuration
+	handoffSignals = []os.Signal{syscall.SIGUSR2} // pass sockets to freshly started binary
+	reopenSignals  = []os.Signal{syscall.SIGUSR1} // reopen --logfile after external rotation
+)
@@ -0,0 +1,17 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"os"
+)
+
+// There are no SIGHUP, SIGUSR1 and SIGUSR2 on windows, reloads are not supported there.
+var (
+	reloadSignals  = []os.Signal{}
+	handoffSignals = []os.Signal{}
+	reopenSignals  = []os.Signal{}
+)
@@ -0,0 +1,211 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// parseTLSVersion converts --sslminversion value (e.g. "1.2") to crypto/tls constant
+func parseTLSVersion(s string) (uint16, error) {
+	if v, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(s), "tls")]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version '%s' (allowed: 1.0, 1.1, 1.2, 1.3)", s)
+}
+
+// parseTLSCiphers converts comma separated list of cipher suite names (as they are
+// named in crypto/tls, e.g. TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256) to their ids.
+func parseTLSCiphers(s string) ([]uint16, error) {
+	if s == "" {
+		return nil, nil
+	}
+	known := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+	for _, suite := range tls.InsecureCipherSuites() {
+		known[suite.Name] = suite.ID
+	}
+
+	ciphers := make([]uint16, 0)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown cipher suite '%s'", name)
+		}
+		ciphers = append(ciphers, id)
+	}
+	return ciphers, nil
+}
+
+var clientAuthModes = map[string]tls.ClientAuthType{
+	"none":     tls.NoClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"require":  tls.RequireAndVerifyClientCert,
+}
+
+// parseClientAuth converts --sslclientauth value to crypto/tls client authentication policy
+func parseClientAuth(s string) (tls.ClientAuthType, error) {
+	if v, ok := clientAuthModes[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	return tls.NoClientCert, fmt.Errorf("unknown client auth mode '%s' (allowed: none, optional, require)", s)
+}
+
+// tlsConfig builds configuration for TLS listeners out of --ssl* flags
+func tlsConfig(config *Config) (*tls.Config, error) {
+	conf := &tls.Config{
+		MinVersion:   config.TLSMinVersion,
+		CipherSuites: config.TLSCiphers,
+		ClientAuth:   config.ClientAuth,
+	}
+
+	if config.ClientCAFile == "" {
+		return conf, nil
+	}
+
+	caPEM, err := ioutil.ReadFile(config.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	conf.ClientCAs = x509.NewCertPool()
+	if !conf.ClientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("no certificates found in %s", config.ClientCAFile)
+	}
+
+	if config.CRLFile != "" {
+		crl, err := loadCRL(config.CRLFile, caPEM)
+		if err != nil {
+			return nil, err
+		}
+		conf.VerifyPeerCertificate = crl.verify
+	}
+	return conf, nil
+}
+
+// revocationList keeps CRLs by raw issuer subject
+type revocationList map[string]*issuerCRL
+
+// issuerCRL is what CRLs of one issuer say
+type issuerCRL struct {
+	revoked    map[string]bool // serial numbers
+	nextUpdate time.Time       // CRL is not trusted after that, zero if not set
+}
+
+// loadCRL reads PEM (could hold several CRLs) or DER encoded CRL file and
+// makes sure every CRL is signed by one of CA certificates and is not expired.
+func loadCRL(path string, caPEM []byte) (revocationList, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ders := make([][]byte, 0)
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "X509 CRL" {
+			ders = append(ders, block.Bytes)
+		}
+	}
+	if len(ders) == 0 {
+		ders = append(ders, data) // not PEM, let's try DER
+	}
+
+	cas := parsePEMCertificates(caPEM)
+	rl := make(revocationList)
+	for _, der := range ders {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse CRL %s: %s", path, err)
+		}
+		var issuer *x509.Certificate
+		for _, ca := range cas {
+			if bytes.Equal(ca.RawSubject, crl.RawIssuer) && crl.CheckSignatureFrom(ca) == nil {
+				issuer = ca
+				break
+			}
+		}
+		if issuer == nil {
+			return nil, fmt.Errorf("CRL of %s in %s is not signed by any of client CA certificates", crl.Issuer, path)
+		}
+
+		if !crl.NextUpdate.IsZero() && time.Now().After(crl.NextUpdate) {
+			return nil, fmt.Errorf("CRL of %s in %s expired on %s, it has to be updated", crl.Issuer, path, crl.NextUpdate.Format(time.RFC3339))
+		}
+
+		ic := rl[string(issuer.RawSubject)]
+		if ic == nil {
+			ic = &issuerCRL{revoked: make(map[string]bool)}
+			rl[string(issuer.RawSubject)] = ic
+		}
+		if ic.nextUpdate.IsZero() || !crl.NextUpdate.IsZero() && crl.NextUpdate.Before(ic.nextUpdate) {
+			ic.nextUpdate = crl.NextUpdate
+		}
+		for _, revoked := range crl.RevokedCertificateEntries {
+			ic.revoked[revoked.SerialNumber.String()] = true
+		}
+	}
+	return rl, nil
+}
+
+// verify is used as tls.Config.VerifyPeerCertificate, it's called after the chain
+// was verified against client CAs. Every certificate is checked against CRL
+// of its own issuer, certificates of issuers whose CRL expired meanwhile are
+// rejected until updated CRL is loaded.
+func (rl revocationList) verify(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		for _, cert := range chain {
+			ic := rl[string(cert.RawIssuer)]
+			if ic == nil {
+				continue
+			}
+			if !ic.nextUpdate.IsZero() && time.Now().After(ic.nextUpdate) {
+				return fmt.Errorf("CRL of %s expired on %s", cert.Issuer, ic.nextUpdate.Format(time.RFC3339))
+			}
+			if ic.revoked[cert.SerialNumber.String()] {
+				return errors.New("client certificate has been revoked")
+			}
+		}
+	}
+	return nil
+}
+
+func parsePEMCertificates(data []byte) []*x509.Certificate {
+	certs := make([]*x509.Certificate, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return certs
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert, key}
+}
+
+// issue returns certificate with given serial signed by ca
+func (ca *testCA) issue(t *testing.T, serial int64) *x509.Certificate {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &ca.key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// crl returns PEM encoded CRL of ca valid until nextUpdate
+func (ca *testCA) crl(t *testing.T, nextUpdate time.Time, revoked ...int64) []byte {
+	tmpl := &x509.RevocationList{Number: big.NewInt(1), ThisUpdate: nextUpdate.Add(-2 * time.Hour), NextUpdate: nextUpdate}
+	for _, serial := range revoked {
+		tmpl.RevokedCertificateEntries = append(tmpl.RevokedCertificateEntries,
+			x509.RevocationListEntry{SerialNumber: big.NewInt(serial), RevocationTime: time.Now()})
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+}
+
+func (ca *testCA) pem() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+}
+
+func TestLoadCRL(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	one, two, other := newTestCA(t, "one"), newTestCA(t, "two"), newTestCA(t, "other")
+	valid := time.Now().Add(time.Hour)
+	caPEM := append(one.pem(), two.pem()...)
+	path := filepath.Join(dir, "crl.pem")
+	if err := ioutil.WriteFile(path, append(one.crl(t, valid, 10), two.crl(t, valid, 20)...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	rl, err := loadCRL(path, caPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ca      *testCA
+		serial  int64
+		revoked bool
+	}{
+		{one, 10, true},
+		{one, 20, false}, // revoked by the other CA only
+		{two, 20, true},
+		{two, 10, false},
+	}
+	for _, tt := range tests {
+		chain := []*x509.Certificate{tt.ca.issue(t, tt.serial), tt.ca.cert}
+		if err := rl.verify(nil, [][]*x509.Certificate{chain}); (err != nil) != tt.revoked {
+			t.Errorf("serial %d of CA %s: got %v, revoked %v", tt.serial, tt.ca.cert.Subject.CommonName, err, tt.revoked)
+		}
+	}
+
+	if err := ioutil.WriteFile(path, other.crl(t, valid, 1), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCRL(path, caPEM); err == nil {
+		t.Error("CRL of unknown CA was accepted")
+	}
+
+	// expired when loaded
+	if err := ioutil.WriteFile(path, append(one.crl(t, valid, 10), two.crl(t, time.Now().Add(-time.Minute))...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCRL(path, caPEM); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Errorf("expired CRL should be refused, got %v", err)
+	}
+
+	// expired while running
+	rl[string(one.cert.RawSubject)].nextUpdate = time.Now().Add(-time.Second)
+	if err := rl.verify(nil, [][]*x509.Certificate{{one.issue(t, 30), one.cert}}); err == nil {
+		t.Error("certificate should be rejected once CRL of its CA expired")
+	}
+	if err := rl.verify(nil, [][]*x509.Certificate{{two.issue(t, 30), two.cert}}); err != nil {
+		t.Errorf("certificate of CA with valid CRL was rejected: %s", err)
+	}
+}
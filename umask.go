@@ -0,0 +1,18 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"syscall"
+)
+
+// umask sets file mode creation mask of the process and returns the old one.
+func umask(mask int) int {
+	return syscall.Umask(mask)
+}
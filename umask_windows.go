@@ -0,0 +1,12 @@
+// Copyright 2013 Joe Walnes and the websocketd team.
+// All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+// There is no umask on windows, permissions of unix sockets are left to chmod.
+
+func umask(mask int) int {
+	return 0
+}